@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Aggregator mode (-aggregate): instead of sampling the local machine, accept
+// samples pushed by other sysdash instances and keep the latest one per host.
+type HostEntry struct {
+	Hostname string    `json:"hostname"`
+	LastSeen time.Time `json:"last_seen"`
+	Metrics  Metrics   `json:"metrics"`
+}
+
+var (
+	hostsMtx   sync.RWMutex
+	hosts      = map[string]HostEntry{}
+	hostsStale = 5 * time.Minute
+	// limits for a single POST /api/ingest, so a buggy agent can't hog memory or a handler
+	ingestMaxBytes int64 = 1 << 20
+	ingestTimeout        = 10 * time.Second
+)
+
+func handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ingestTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+	// body reads don't observe the context, so bound the socket read too
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(ingestTimeout))
+
+	r.Body = http.MaxBytesReader(w, r.Body, ingestMaxBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var m Metrics
+	if err := dec.Decode(&m); err != nil {
+		var maxErr *http.MaxBytesError
+		var netErr net.Error
+		switch {
+		case errors.As(err, &maxErr):
+			http.Error(w, fmt.Sprintf("sample exceeds %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge)
+		case ctx.Err() != nil, errors.As(err, &netErr) && netErr.Timeout():
+			http.Error(w, "timed out reading sample", http.StatusRequestTimeout)
+		default:
+			http.Error(w, "bad sample: "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if m.Hostname == "" {
+		http.Error(w, "sample has no hostname", http.StatusBadRequest)
+		return
+	}
+	hostsMtx.Lock()
+	hosts[m.Hostname] = HostEntry{Hostname: m.Hostname, LastSeen: time.Now(), Metrics: m}
+	hostsMtx.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleHosts(w http.ResponseWriter, r *http.Request) {
+	cutoff := time.Now().Add(-hostsStale)
+	hostsMtx.RLock()
+	out := make([]HostEntry, 0, len(hosts))
+	for _, h := range hosts {
+		if h.LastSeen.After(cutoff) {
+			out = append(out, h)
+		}
+	}
+	hostsMtx.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Hostname < out[j].Hostname })
+	b, _ := marshalJSON(out)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// evictLoop drops hosts that stopped reporting so the map doesn't grow forever
+// as machines come and go.
+func evictLoop() {
+	t := time.NewTicker(hostsStale / 2)
+	defer t.Stop()
+	for range t.C {
+		cutoff := time.Now().Add(-hostsStale)
+		hostsMtx.Lock()
+		for name, h := range hosts {
+			if h.LastSeen.Before(cutoff) {
+				delete(hosts, name)
+				log.Printf("[aggregate] evicted %s (last seen %s)", name, h.LastSeen.Format(time.RFC3339))
+			}
+		}
+		hostsMtx.Unlock()
+	}
+}
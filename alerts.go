@@ -0,0 +1,284 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alert is a condition that needs a human, attached to the sample it was
+// detected in. Alerts are derived in collectLoop after sampling, so rules can
+// look at the whole Metrics and keep state across samples.
+type Alert struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"` // "warning" or "critical"
+	Msg      string `json:"msg"`
+}
+
+// alerter holds the per-rule state carried between samples.
+type alerter struct {
+	// mounts seen writable at least once; a mount that was read-only from the
+	// start (a ro /boot, a recovery partition) is intentional, not an alert
+	wasRW map[string]bool
+}
+
+func newAlerter() *alerter {
+	return &alerter{wasRW: map[string]bool{}}
+}
+
+// eval returns the alerts for m. past looks up the newest earlier sample at or
+// before a time, for the rate-of-change rules; it reports false when the
+// history doesn't reach back that far.
+func (a *alerter) eval(m Metrics, past func(time.Time) (Metrics, bool)) []Alert {
+	var out []Alert
+	for _, d := range m.Disks {
+		if !d.ReadOnly {
+			a.wasRW[d.Mount] = true
+			continue
+		}
+		if a.wasRW[d.Mount] {
+			// typically ext4 errors=remount-ro after I/O errors on a dying card
+			out = append(out, Alert{
+				Name:     "disk_read_only",
+				Severity: "critical",
+				Msg:      fmt.Sprintf("%s (%s) was remounted read-only", d.Mount, d.Device),
+			})
+		}
+	}
+	for _, r := range m.RAIDArrays {
+		if r.Degraded {
+			msg := fmt.Sprintf("%s is degraded: %d of %d devices active", r.Name, r.Active, r.Devices)
+			if r.SyncAction == "recovery" {
+				msg += fmt.Sprintf(", rebuilding (%.1f%%)", r.SyncPercent)
+			}
+			out = append(out, Alert{Name: "raid_degraded", Severity: "critical", Msg: msg})
+		}
+	}
+	out = append(out, evalTempRules(m)...)
+	out = append(out, evalRateRules(m, past)...)
+	return out
+}
+
+// tempRule is one SYSDASH_TEMP_ALERT entry, e.g. CPU:80. match is a
+// lowercased substring of a sensor's friendly name, type or label (a drive's
+// device name for disk temperatures); "*" matches any sensor.
+type tempRule struct {
+	match string
+	limit float64
+}
+
+// tempAlertRules (SYSDASH_TEMP_ALERT=CPU:80,nvme:70,*:90) are tried in order
+// and the first match sets a sensor's limit, so specific entries go before a
+// "*" default. Sensors nothing matches are not checked.
+var tempAlertRules []tempRule
+
+func tempLimit(names ...string) (float64, bool) {
+	for _, r := range tempAlertRules {
+		if r.match == "*" {
+			return r.limit, true
+		}
+		for _, n := range names {
+			if n != "" && strings.Contains(strings.ToLower(n), r.match) {
+				return r.limit, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func evalTempRules(m Metrics) []Alert {
+	var out []Alert
+	if len(tempAlertRules) == 0 {
+		return out
+	}
+	fire := func(name string, c, limit float64) {
+		out = append(out, Alert{
+			Name:     "temp_high",
+			Severity: "warning",
+			Msg:      fmt.Sprintf("%s at %.1f°C (limit %g°C)", name, c, limit),
+		})
+	}
+	for _, t := range m.Temps {
+		if limit, ok := tempLimit(t.FriendlyName, t.Sensor, t.Label); ok && t.C >= limit {
+			fire(cmp.Or(t.FriendlyName, t.Sensor), t.C, limit)
+		}
+	}
+	for _, d := range m.DiskTemps {
+		if limit, ok := tempLimit(d.Device); ok && d.C >= limit {
+			fire(d.Device, d.C, limit)
+		}
+	}
+	return out
+}
+
+func parseTempRules(v string) ([]tempRule, error) {
+	var out []tempRule
+	for _, p := range strings.Split(v, ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		// split at the last colon, so a sensor name may contain one
+		i := strings.LastIndexByte(p, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("%q: want sensor:limit", p)
+		}
+		match := strings.ToLower(strings.TrimSpace(p[:i]))
+		limit, err := strconv.ParseFloat(strings.TrimSpace(p[i+1:]), 64)
+		if match == "" || err != nil {
+			return nil, fmt.Errorf("%q: want sensor:limit, e.g. CPU:80", p)
+		}
+		out = append(out, tempRule{match, limit})
+	}
+	return out, nil
+}
+
+// rateRule fires when a metric moves by at least amount within window. The
+// drop rules take an amount in bytes, the rise rule a factor.
+type rateRule struct {
+	amount float64
+	window time.Duration
+}
+
+// Rate-of-change rules, off unless configured:
+//
+//	SYSDASH_ALERT_DISK_DROP=10GiB/10m  available space on a mount fell by 10 GiB
+//	SYSDASH_ALERT_MEM_DROP=2GiB/5m     available memory fell by 2 GiB
+//	SYSDASH_ALERT_LOAD_RISE=2x/1m      load1 at least doubled (and rose by 1 or more;
+//	                                   with PSI, CPU pressure rose by 10 points or more)
+//
+// They compare against the history, so the window has to fit in
+// SYSDASH_HISTORY_LEN/SYSDASH_HISTORY_DURATION.
+var alertDiskDrop, alertMemDrop, alertLoadRise *rateRule
+
+func evalRateRules(m Metrics, past func(time.Time) (Metrics, bool)) []Alert {
+	var out []Alert
+	if r := alertDiskDrop; r != nil {
+		if p, ok := past(m.Timestamp.Add(-r.window)); ok {
+			was := map[string]uint64{}
+			for _, d := range p.Disks {
+				was[d.Mount] = d.AvailB
+			}
+			for _, d := range m.Disks {
+				if prev, ok := was[d.Mount]; ok && prev > d.AvailB && float64(prev-d.AvailB) >= r.amount {
+					out = append(out, Alert{
+						Name:     "disk_drop",
+						Severity: "warning",
+						Msg: fmt.Sprintf("free space on %s dropped %s in %s (now %s)",
+							d.Mount, humanBytes(prev-d.AvailB), r.window, humanBytes(d.AvailB)),
+					})
+				}
+			}
+		}
+	}
+	if r := alertMemDrop; r != nil {
+		if p, ok := past(m.Timestamp.Add(-r.window)); ok && p.MemAvailB > m.MemAvailB && float64(p.MemAvailB-m.MemAvailB) >= r.amount {
+			out = append(out, Alert{
+				Name:     "mem_drop",
+				Severity: "warning",
+				Msg: fmt.Sprintf("available memory dropped %s in %s (now %s)",
+					humanBytes(p.MemAvailB-m.MemAvailB), r.window, humanBytes(m.MemAvailB)),
+			})
+		}
+	}
+	if r := alertLoadRise; r != nil {
+		// the floor keeps an idle box going from 0.05 to 0.2 (or 1% to 3% CPU
+		// pressure) quiet; load average and PSI percentages don't compare, so
+		// a sample taken before the source changed is skipped
+		floor, msg := 1.0, "load1 rose from %.2f to %.2f in %s"
+		if m.LoadSource == "psi" {
+			floor, msg = 10, "CPU pressure rose from %.1f%% to %.1f%% in %s"
+		}
+		if p, ok := past(m.Timestamp.Add(-r.window)); ok && p.LoadSource == m.LoadSource && m.Load1 >= r.amount*p.Load1 && m.Load1-p.Load1 >= floor {
+			out = append(out, Alert{
+				Name:     "load_rise",
+				Severity: "warning",
+				Msg:      fmt.Sprintf(msg, p.Load1, m.Load1, r.window),
+			})
+		}
+	}
+	return out
+}
+
+// loadAlertConfig parses the SYSDASH_ALERT_* rate rules.
+func loadAlertConfig() error {
+	for _, c := range []struct {
+		key    string
+		dst    **rateRule
+		factor bool
+	}{
+		{"SYSDASH_ALERT_DISK_DROP", &alertDiskDrop, false},
+		{"SYSDASH_ALERT_MEM_DROP", &alertMemDrop, false},
+		{"SYSDASH_ALERT_LOAD_RISE", &alertLoadRise, true},
+	} {
+		v := os.Getenv(c.key)
+		if v == "" {
+			continue
+		}
+		r, err := parseRateRule(v, c.factor)
+		if err != nil {
+			return fmt.Errorf("%s=%q: %v", c.key, v, err)
+		}
+		*c.dst = r
+	}
+	if v := os.Getenv("SYSDASH_TEMP_ALERT"); v != "" {
+		r, err := parseTempRules(v)
+		if err != nil {
+			return fmt.Errorf("SYSDASH_TEMP_ALERT=%q: %v", v, err)
+		}
+		tempAlertRules = r
+	}
+	return nil
+}
+
+// parseRateRule parses "<amount>/<window>": "10GiB/10m", or "2x/1m" when
+// factor is set.
+func parseRateRule(v string, factor bool) (*rateRule, error) {
+	amt, win, ok := strings.Cut(v, "/")
+	if !ok {
+		return nil, fmt.Errorf("want <amount>/<window>, e.g. 10GiB/10m")
+	}
+	w, err := time.ParseDuration(strings.TrimSpace(win))
+	if err != nil || w <= 0 {
+		return nil, fmt.Errorf("bad window %q", win)
+	}
+	amt = strings.TrimSpace(amt)
+	if factor {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(amt, "x"), 64)
+		if err != nil || f <= 1 {
+			return nil, fmt.Errorf("want a factor above 1 such as 2x, got %q", amt)
+		}
+		return &rateRule{f, w}, nil
+	}
+	n, err := parseBytes(amt)
+	if err != nil {
+		return nil, err
+	}
+	return &rateRule{float64(n), w}, nil
+}
+
+// parseBytes reads a size such as 512, 10GiB or 1.5GB. The IEC suffixes are
+// powers of 1024, the SI ones powers of 1000.
+func parseBytes(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	}
+	mult := 1.0
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("bad size %q, want e.g. 10GiB", s)
+	}
+	return uint64(f * mult), nil
+}
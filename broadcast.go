@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// Live subscribers (WebSocket clients, ...) receive each sample's JSON as soon
+// as collectLoop produces it. Every subscriber gets a 1-slot channel; a slow
+// reader only ever sees the newest sample rather than holding up the others.
+var (
+	subsMtx sync.Mutex
+	subs    = map[chan []byte]struct{}{}
+)
+
+func subscribe() chan []byte {
+	ch := make(chan []byte, 1)
+	subsMtx.Lock()
+	subs[ch] = struct{}{}
+	subsMtx.Unlock()
+	return ch
+}
+
+func unsubscribe(ch chan []byte) {
+	subsMtx.Lock()
+	delete(subs, ch)
+	subsMtx.Unlock()
+}
+
+func broadcast(b []byte) {
+	subsMtx.Lock()
+	defer subsMtx.Unlock()
+	for ch := range subs {
+		// drop whatever the subscriber hasn't picked up yet, then queue b
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- b
+	}
+}
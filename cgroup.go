@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// cgroupMem (SYSDASH_CGROUP_MEM, default on) reports memory against the
+// cgroup's limit when sysdash runs in a memory-limited container, where
+// /proc/meminfo shows the host.
+var cgroupMem = true
+
+// cgroupV1Unlimited is about what v1 reports for "no limit": the largest
+// int64 rounded down to the page size. Anything this large isn't a limit.
+const cgroupV1Unlimited = 1 << 62
+
+// readCgroupMem returns the memory limit of sysdash's cgroup and its working
+// set (usage minus inactive page cache, which the kernel reclaims before it
+// OOM-kills, as docker stats and the kubelet count it). ok is false when
+// there is no limit: on the host, in the root cgroup, or with memory.max at
+// "max". cgroup v2 is tried first, then the v1 memory controller.
+func readCgroupMem() (limit, used uint64, ok bool, err error) {
+	for _, c := range []struct{ max, current, inactive string }{
+		{"fs/cgroup/memory.max", "fs/cgroup/memory.current", "inactive_file"},
+		{"fs/cgroup/memory/memory.limit_in_bytes", "fs/cgroup/memory/memory.usage_in_bytes", "total_inactive_file"},
+	} {
+		s, e := readFile(sysPath(c.max))
+		if errors.Is(e, fs.ErrNotExist) {
+			continue
+		}
+		if e != nil {
+			return 0, 0, false, e
+		}
+		if s == "max" {
+			return 0, 0, false, nil
+		}
+		if limit, e = strconv.ParseUint(s, 10, 64); e != nil {
+			return 0, 0, false, fmt.Errorf("%s: %w", sysPath(c.max), e)
+		}
+		if limit >= cgroupV1Unlimited {
+			return 0, 0, false, nil
+		}
+		cur, e := readFile(sysPath(c.current))
+		if e != nil {
+			return 0, 0, false, e
+		}
+		if used, e = strconv.ParseUint(cur, 10, 64); e != nil {
+			return 0, 0, false, fmt.Errorf("%s: %w", sysPath(c.current), e)
+		}
+		dir := c.max[:strings.LastIndexByte(c.max, '/')]
+		if b, e := readRetry(sysPath(dir, "memory.stat")); e == nil {
+			sc := bufio.NewScanner(bytes.NewReader(b))
+			for sc.Scan() {
+				k, v, _ := strings.Cut(sc.Text(), " ")
+				if k == c.inactive {
+					n, _ := strconv.ParseUint(v, 10, 64)
+					used -= min(n, used)
+					break
+				}
+			}
+		}
+		return limit, used, true, nil
+	}
+	return 0, 0, false, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// tcpStates maps the hex st column of /proc/net/tcp{,6} to a state name.
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+}
+
+// readConns counts sockets per TCP state plus a "udp" total for one address
+// family: suffix is "" for IPv4 and "6" for IPv6. A kernel built without
+// IPv6 has no tcp6/udp6, which yields an empty map rather than an error.
+func readConns(suffix string) (map[string]int, error) {
+	out := map[string]int{}
+	for _, proto := range []string{"tcp", "udp"} {
+		b, err := readRetry(procPath("net/" + proto + suffix))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		sc.Scan() // header
+		for sc.Scan() {
+			f := strings.Fields(sc.Text())
+			if len(f) < 4 {
+				continue
+			}
+			if proto == "udp" {
+				out["udp"]++
+			} else if st, ok := tcpStates[f[3]]; ok {
+				out[st]++
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CoreStat joins one logical CPU's utilization, current frequency and, where
+// the sensor reports per-core readings (Intel coretemp), its temperature.
+type CoreStat struct {
+	Index   int     `json:"index"`
+	Percent float64 `json:"percent"`
+	FreqMHz float64 `json:"freq_mhz,omitempty"`
+	TempC   float64 `json:"temp_c,omitempty"`
+}
+
+type coreInfo struct {
+	freqMHz float64
+	coreID  int // physical core id from topology, -1 if unknown
+}
+
+// readCoreInfo reads the cpufreq and topology sysfs entries for the given CPUs.
+// Missing cpufreq (VMs, some ARM boards) just leaves the frequency at 0.
+func readCoreInfo(cpus map[int]CPUTimes) (map[int]coreInfo, error) {
+	out := make(map[int]coreInfo, len(cpus))
+	for n := range cpus {
+		dir := "devices/system/cpu/cpu" + strconv.Itoa(n)
+		ci := coreInfo{coreID: -1}
+		// scaling_cur_freq is in kHz
+		if khz := readUint(sysPath(dir, "cpufreq/scaling_cur_freq")); khz > 0 {
+			ci.freqMHz = float64(khz) / 1000
+		}
+		if s, err := readFile(sysPath(dir, "topology/core_id")); err == nil {
+			if id, err := strconv.Atoi(s); err == nil {
+				ci.coreID = id
+			}
+		}
+		out[n] = ci
+	}
+	return out, nil
+}
+
+// coreTemps maps coretemp's "Core N" labels to N. Other drivers (k10temp,
+// zenpower) only report package-level readings and contribute nothing.
+func coreTemps(temps []Temp) map[int]float64 {
+	out := map[int]float64{}
+	for _, t := range temps {
+		if t.Sensor != "coretemp" {
+			continue
+		}
+		rest, ok := strings.CutPrefix(t.Label, "Core ")
+		if !ok {
+			continue
+		}
+		if id, err := strconv.Atoi(rest); err == nil {
+			// on multi-socket boxes the same id repeats per package; keep the first
+			if _, seen := out[id]; !seen {
+				out[id] = t.C
+			}
+		}
+	}
+	return out
+}
+
+// readCores builds the per-CPU list, sorted by index. CPUs without a previous
+// reading (just came online) report 0% for this interval.
+func readCores(prev, cur map[int]CPUTimes, info map[int]coreInfo, temps []Temp) []CoreStat {
+	ct := coreTemps(temps)
+	out := make([]CoreStat, 0, len(cur))
+	for n, t := range cur {
+		c := CoreStat{Index: n}
+		if p, ok := prev[n]; ok {
+			c.Percent = cpuBreakdown(p, t).Busy
+		}
+		if ci, ok := info[n]; ok {
+			c.FreqMHz = ci.freqMHz
+			if ci.coreID >= 0 {
+				c.TempC = ct[ci.coreID]
+			}
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Index < out[j].Index })
+	return out
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DiagResult is one collector's outcome in /api/diag.
+type DiagResult struct {
+	Collector  string  `json:"collector"`
+	Enabled    bool    `json:"enabled"`
+	OK         bool    `json:"ok"`
+	DurationMs float64 `json:"duration_ms"`
+	Sample     string  `json:"sample,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// diagChecks runs each collector and condenses what it found into a line.
+// Several readers skip what they can't read instead of failing, so an empty
+// result is reported as a problem too: that is the "why are my temps empty"
+// case /api/diag exists for.
+var diagChecks = []struct {
+	name string
+	run  func() (string, error)
+}{
+	{"cpu", func() (string, error) {
+		cs, err := readCPUStat()
+		return fmt.Sprintf("%d CPUs, %d context switches since boot", len(cs.perCPU), cs.ctxt), err
+	}},
+	{"mem", func() (string, error) {
+		total, avail, _, _, _, err := readMem()
+		return fmt.Sprintf("total %s, available %s", humanBytes(total), humanBytes(avail)), err
+	}},
+	{"load", func() (string, error) {
+		l1, l5, l15, err := readLoad()
+		return fmt.Sprintf("%.2f %.2f %.2f", l1, l5, l15), err
+	}},
+	{"uptime", func() (string, error) {
+		up, err := readUptime()
+		return (time.Duration(up) * time.Second).String(), err
+	}},
+	{"net", func() (string, error) { return countOrErr(len(readNet()), "interfaces", sysPath("class/net")) }},
+	{"conns", func() (string, error) {
+		c, err := readConns("")
+		if err != nil {
+			return "", err
+		}
+		c6, err := readConns("6")
+		return fmt.Sprintf("%d established (IPv4), %d (IPv6)", c["established"], c6["established"]), err
+	}},
+	{"temps", func() (string, error) {
+		return countOrErr(len(readTemps()), "sensors", sysPath("class/thermal")+" or "+sysPath("class/hwmon"))
+	}},
+	{"disks", func() (string, error) {
+		d, err := readDisks()
+		if err != nil {
+			return "", err
+		}
+		return countOrErr(len(d), "filesystems", procPath("mounts"))
+	}},
+	{"diskio", func() (string, error) {
+		d, err := readDiskIO()
+		if err != nil {
+			return "", err
+		}
+		return countOrErr(len(d), "block devices", sysPath("block"))
+	}},
+	{"disktemps", func() (string, error) {
+		if diskTempMode == "" {
+			return "off (SYSDASH_DISK_TEMP not set)", nil
+		}
+		t, err := readDiskTemps()
+		return fmt.Sprintf("%d drives", len(t)), err
+	}},
+	{"raid", func() (string, error) {
+		r, err := readRAID()
+		if err == nil && r == nil {
+			return "no md arrays (" + procPath("mdstat") + " missing or empty)", nil
+		}
+		degraded := 0
+		for _, a := range r {
+			if a.Degraded {
+				degraded++
+			}
+		}
+		return fmt.Sprintf("%d arrays, %d degraded", len(r), degraded), err
+	}},
+	{"psi", func() (string, error) {
+		p, err := readPSI()
+		if err == nil && p == nil {
+			return "", fmt.Errorf("%s missing: kernel without CONFIG_PSI or booted with psi=0", procPath("pressure"))
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("cpu some avg10 %.2f", p.CPU.Some.Avg10), nil
+	}},
+	{"kernel", func() (string, error) {
+		k, err := readKernelMisc()
+		return fmt.Sprintf("entropy %d, %d/%d file handles", k.entropy, k.fdAlloc, k.fdMax), err
+	}},
+	{"gpu", func() (string, error) {
+		if !gpuEnabled {
+			return "off (SYSDASH_GPU not set)", nil
+		}
+		g, err := readGPU()
+		return fmt.Sprintf("%d GPUs", len(g)), err
+	}},
+	{"procs", func() (string, error) {
+		w, err := readProcs(nil, 0)
+		if err != nil {
+			return "", err
+		}
+		unreadable := 0
+		for _, p := range w.top {
+			if p.FDCount < 0 {
+				unreadable++
+			}
+		}
+		s := fmt.Sprintf("%d processes, %d threads", w.procs, w.threads)
+		if unreadable > 0 {
+			s += fmt.Sprintf("; fd counts unreadable for %d of the top %d (needs root or CAP_SYS_PTRACE)", unreadable, len(w.top))
+		}
+		return s, nil
+	}},
+}
+
+func countOrErr(n int, what, where string) (string, error) {
+	if n == 0 {
+		return "", fmt.Errorf("no %s found under %s", what, where)
+	}
+	return fmt.Sprintf("%d %s", n, what), nil
+}
+
+// runDiag runs every collector once, with the usual collectTimeout, whether
+// or not SYSDASH_COLLECTORS enables it.
+func runDiag() []DiagResult {
+	out := make([]DiagResult, 0, len(diagChecks))
+	for _, c := range diagChecks {
+		start := time.Now()
+		s, err := collect("diag/"+c.name, c.run)
+		r := DiagResult{
+			Collector:  c.name,
+			Enabled:    collectorOn(c.name),
+			OK:         err == nil,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Sample:     s,
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func handleDiag(w http.ResponseWriter, r *http.Request) {
+	b, _ := marshalJSON(runDiag())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DiskIOStat is the I/O on one whole block device, from /proc/diskstats.
+type DiskIOStat struct {
+	Device      string  `json:"device"`
+	ReadBytes   uint64  `json:"read_bytes"`
+	WriteBytes  uint64  `json:"write_bytes"`
+	ReadBps     float64 `json:"read_bps"`
+	WriteBps    float64 `json:"write_bps"`
+	UtilPercent float64 `json:"util_percent"` // share of wall time with I/O in flight, like iostat %util
+	ioTicks     uint64  // ms spent doing I/O
+}
+
+// readDiskIO returns the counters of the devices in /sys/block, which lists
+// whole disks but not partitions. Loop and RAM disks are left out.
+func readDiskIO() ([]DiskIOStat, error) {
+	b, err := readRetry(procPath("diskstats"))
+	if err != nil {
+		return nil, err
+	}
+	var out []DiskIOStat
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 13 {
+			continue
+		}
+		name := f[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		if _, err := os.Stat(sysPath("block", name)); err != nil {
+			continue
+		}
+		// sectors are always 512 bytes here, whatever the device's block size
+		rd, _ := strconv.ParseUint(f[5], 10, 64)
+		wr, _ := strconv.ParseUint(f[9], 10, 64)
+		ticks, _ := strconv.ParseUint(f[12], 10, 64)
+		out = append(out, DiskIOStat{Device: name, ReadBytes: rd * 512, WriteBytes: wr * 512, ioTicks: ticks})
+	}
+	return out, sc.Err()
+}
+
+// applyDiskIORates fills in the per-second fields of cur from the previous sample.
+func applyDiskIORates(cur []DiskIOStat, prev map[string]DiskIOStat, elapsed float64) {
+	for i := range cur {
+		d := &cur[i]
+		p, ok := prev[d.Device]
+		if !ok {
+			continue
+		}
+		d.ReadBps = counterRate(p.ReadBytes, d.ReadBytes, elapsed)
+		d.WriteBps = counterRate(p.WriteBytes, d.WriteBytes, elapsed)
+		// io_ticks is in ms; the two reads are not taken at exactly the
+		// sample times, so clamp the jitter
+		d.UtilPercent = min(counterRate(p.ioTicks, d.ioTicks, elapsed)/1000*100, 100)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type DiskTemp struct {
+	Device string  `json:"device"`
+	C      float64 `json:"celsius"`
+}
+
+// diskTempMode is SYSDASH_DISK_TEMP: "" (off), "1" for the sysfs sensors
+// (NVMe, and SATA drives when the drivetemp module is loaded), or "smart" to
+// also ask smartctl about SATA drives sysfs doesn't cover.
+var diskTempMode string
+
+func readDiskTemps() ([]DiskTemp, error) {
+	if diskTempMode == "" {
+		return nil, nil
+	}
+	temps := readHwmonDiskTemps()
+	if diskTempMode != "smart" {
+		return temps, nil
+	}
+	seen := map[string]bool{}
+	for _, t := range temps {
+		seen[t.Device] = true
+	}
+	smart, err := readSmartTemps(seen)
+	return append(temps, smart...), err
+}
+
+// readHwmonDiskTemps reads the hwmon chips registered by the nvme and
+// drivetemp drivers, named after the block device (nvme0n1, sda) they belong to.
+func readHwmonDiskTemps() []DiskTemp {
+	var out []DiskTemp
+	chips, _ := filepath.Glob(sysPath("class/hwmon/hwmon*"))
+	for _, chip := range chips {
+		name, err := readFile(filepath.Join(chip, "name"))
+		if err != nil || (name != "nvme" && name != "drivetemp") {
+			continue
+		}
+		raw, err := readFile(filepath.Join(chip, "temp1_input"))
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		// hwmon/device is the nvme controller or SCSI device; its block/ dir
+		// (nvme: one level further down, under the namespace) names the disk
+		dev := filepath.Base(chip)
+		blocks, _ := filepath.Glob(filepath.Join(chip, "device", "block", "*"))
+		if len(blocks) == 0 {
+			blocks, _ = filepath.Glob(filepath.Join(chip, "device", "nvme*n*"))
+		}
+		if len(blocks) > 0 {
+			dev = filepath.Base(blocks[0])
+		}
+		out = append(out, DiskTemp{Device: dev, C: v / 1000})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Device < out[j].Device })
+	return out
+}
+
+// readSmartTemps runs smartctl for every sd* disk not in skip. -n standby
+// leaves sleeping drives asleep (smartctl then exits 2 and we skip them); a
+// missing smartctl is reported once per sample in LastError.
+func readSmartTemps(skip map[string]bool) ([]DiskTemp, error) {
+	bin, err := exec.LookPath("smartctl")
+	if err != nil {
+		return nil, errors.New("smartctl not found")
+	}
+	disks, _ := filepath.Glob(sysPath("class/block/sd*"))
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	var out []DiskTemp
+	for _, d := range disks {
+		dev := filepath.Base(d)
+		// partitions (sda1) have a "partition" file; whole disks don't
+		if _, err := os.Stat(filepath.Join(d, "partition")); err == nil || skip[dev] {
+			continue
+		}
+		raw, err := exec.CommandContext(ctx, bin, "-n", "standby", "-A", "/dev/"+dev).Output()
+		if ctx.Err() != nil {
+			return out, errors.New("smartctl timed out")
+		}
+		var ee *exec.ExitError
+		if err != nil && !errors.As(err, &ee) {
+			return out, err
+		}
+		// smartctl's exit status is a bitmask of health findings, so a non-zero
+		// exit with attribute output is still usable
+		if c, ok := parseSmartTemp(string(raw)); ok {
+			out = append(out, DiskTemp{Device: dev, C: c})
+		}
+	}
+	return out, nil
+}
+
+// parseSmartTemp finds attribute 194 (Temperature_Celsius), or 190
+// (Airflow_Temperature_Cel) if that's all the drive has, in `smartctl -A`
+// output. The raw value may carry a min/max suffix: "34 (Min/Max 20/45)".
+func parseSmartTemp(out string) (float64, bool) {
+	var airflow float64
+	haveAirflow := false
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		// ID# ATTRIBUTE_NAME FLAG VALUE WORST THRESH TYPE UPDATED WHEN_FAILED RAW_VALUE
+		f := strings.Fields(sc.Text())
+		if len(f) < 10 || (f[0] != "194" && f[0] != "190") {
+			continue
+		}
+		v, err := strconv.ParseFloat(f[9], 64)
+		if err != nil {
+			continue
+		}
+		if f[0] == "194" {
+			return v, true
+		}
+		airflow, haveAirflow = v, true
+	}
+	return airflow, haveAirflow
+}
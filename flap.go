@@ -0,0 +1,69 @@
+package main
+
+import "time"
+
+// flapWindow (SYSDASH_FLAP_WINDOW) is how far back NetStat.FlapCount looks.
+var flapWindow = time.Hour
+
+type flapEvent struct {
+	at time.Time
+	n  int
+}
+
+// flapTracker remembers link state changes per interface across samples. The
+// kernel's carrier_changes counter catches drops shorter than the sampling
+// interval; drivers without it fall back to comparing operstate.
+type flapTracker struct {
+	events map[string][]flapEvent
+	last   map[string]time.Time
+}
+
+func newFlapTracker() *flapTracker {
+	return &flapTracker{events: map[string][]flapEvent{}, last: map[string]time.Time{}}
+}
+
+// update fills FlapCount and LastChange of cur from the previous sample and
+// forgets interfaces that are gone, so short-lived veth and tun devices don't
+// pile up.
+func (ft *flapTracker) update(cur []NetStat, prev map[string]NetStat, now time.Time) {
+	cutoff := now.Add(-flapWindow)
+	seen := make(map[string]bool, len(cur))
+	for _, n := range cur {
+		seen[n.Name] = true
+	}
+	for name := range ft.events {
+		if !seen[name] {
+			delete(ft.events, name)
+		}
+	}
+	for name := range ft.last {
+		if !seen[name] {
+			delete(ft.last, name)
+		}
+	}
+	for i := range cur {
+		n := &cur[i]
+		if p, ok := prev[n.Name]; ok {
+			changes := 0
+			if n.CarrierChanges > p.CarrierChanges {
+				changes = int(n.CarrierChanges - p.CarrierChanges)
+			}
+			if changes == 0 && n.OperUp != p.OperUp {
+				changes = 1
+			}
+			if changes > 0 {
+				ft.events[n.Name] = append(ft.events[n.Name], flapEvent{now, changes})
+				ft.last[n.Name] = now
+			}
+		}
+		ev := ft.events[n.Name]
+		for len(ev) > 0 && ev[0].at.Before(cutoff) {
+			ev = ev[1:]
+		}
+		ft.events[n.Name] = ev
+		for _, e := range ev {
+			n.FlapCount += e.n
+		}
+		n.LastChange = ft.last[n.Name]
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// forecastMinWindow (SYSDASH_FORECAST_MIN_WINDOW) is how much history a mount
+// needs before /api/disk-forecast estimates anything; a few minutes of
+// samples mostly measure log rotation.
+var forecastMinWindow = time.Hour
+
+// DiskForecast is a linear fit of one mount's used bytes over the history.
+// FillRateBytesPerDay is null until the mount has forecastMinWindow of
+// samples; DaysUntilFull is also null while usage is flat or shrinking.
+type DiskForecast struct {
+	Mount               string   `json:"mount"`
+	Device              string   `json:"device"`
+	UsedBytes           uint64   `json:"used_bytes"`
+	AvailBytes          uint64   `json:"avail_bytes"`
+	Samples             int      `json:"samples"`
+	WindowSec           float64  `json:"window_sec"`
+	FillRateBytesPerDay *float64 `json:"fill_rate_bytes_per_day"`
+	DaysUntilFull       *float64 `json:"days_until_full"`
+	Note                string   `json:"note,omitempty"`
+}
+
+// diskForecast fits each mount in the newest sample against the history,
+// oldest first, skipping samples where the mount is missing.
+func diskForecast(h []Metrics) []DiskForecast {
+	out := []DiskForecast{}
+	if len(h) == 0 {
+		return out
+	}
+	last := h[len(h)-1]
+	for _, d := range last.Disks {
+		f := DiskForecast{Mount: d.Mount, Device: d.Device, UsedBytes: d.TotalB - d.FreeB, AvailBytes: d.AvailB}
+		var first time.Time
+		var n, sx, sy, sxx, sxy float64
+		for _, m := range h {
+			for _, md := range m.Disks {
+				if md.Mount != d.Mount {
+					continue
+				}
+				if first.IsZero() {
+					first = m.Timestamp
+				}
+				// days since the first sample keep x small for the sums
+				x := m.Timestamp.Sub(first).Hours() / 24
+				y := float64(md.TotalB - md.FreeB)
+				n++
+				sx += x
+				sy += y
+				sxx += x * x
+				sxy += x * y
+				break
+			}
+		}
+		f.Samples = int(n)
+		f.WindowSec = last.Timestamp.Sub(first).Seconds()
+		den := n*sxx - sx*sx
+		switch {
+		case f.WindowSec < forecastMinWindow.Seconds():
+			f.Note = fmt.Sprintf("need %s of history for an estimate", forecastMinWindow)
+		case den == 0:
+			f.Note = "not enough samples"
+		default:
+			slope := (n*sxy - sx*sy) / den
+			f.FillRateBytesPerDay = &slope
+			if slope > 0 {
+				days := float64(d.AvailB) / slope
+				f.DaysUntilFull = &days
+			} else {
+				f.Note = "usage is flat or shrinking"
+			}
+		}
+		out = append(out, f)
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type GPUStat struct {
+	Index       int     `json:"index"`
+	Vendor      string  `json:"vendor"`
+	Name        string  `json:"name,omitempty"`
+	UtilPercent float64 `json:"util_percent"`
+	MemUsedB    uint64  `json:"mem_used_bytes"`
+	MemTotalB   uint64  `json:"mem_total_bytes"`
+	TempC       float64 `json:"temp_celsius"`
+	PowerW      float64 `json:"power_watts,omitempty"`
+}
+
+// gpuEnabled gates the GPU collectors (SYSDASH_GPU=1); nvidia-smi is too
+// expensive to run on every sample of a box that has no GPU.
+var gpuEnabled bool
+
+func readGPU() ([]GPUStat, error) {
+	if !gpuEnabled {
+		return nil, nil
+	}
+	amd := readAMDGPU()
+	nv, err := readNvidiaGPU()
+	return append(amd, nv...), err
+}
+
+const pciVendorAMD = "0x1002"
+
+// readAMDGPU reads amdgpu's sysfs files directly, no tools needed:
+// gpu_busy_percent, VRAM counters and the hwmon temp/power of each card.
+func readAMDGPU() []GPUStat {
+	var gpus []GPUStat
+	cards, _ := filepath.Glob(sysPath("class/drm/card*"))
+	for _, card := range cards {
+		// skip connector entries such as card0-DP-1
+		idx, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(card), "card"))
+		if err != nil {
+			continue
+		}
+		dev := filepath.Join(card, "device")
+		if v, _ := readFile(filepath.Join(dev, "vendor")); v != pciVendorAMD {
+			continue
+		}
+		busy, err := readFile(filepath.Join(dev, "gpu_busy_percent"))
+		if err != nil {
+			continue // not an amdgpu-driven card
+		}
+		g := GPUStat{
+			Index:     idx,
+			Vendor:    "amd",
+			MemUsedB:  readUint(filepath.Join(dev, "mem_info_vram_used")),
+			MemTotalB: readUint(filepath.Join(dev, "mem_info_vram_total")),
+		}
+		g.UtilPercent, _ = strconv.ParseFloat(busy, 64)
+		if hw, _ := filepath.Glob(filepath.Join(dev, "hwmon/hwmon*")); len(hw) > 0 {
+			g.TempC = float64(readUint(filepath.Join(hw[0], "temp1_input"))) / 1000    // millidegrees
+			g.PowerW = float64(readUint(filepath.Join(hw[0], "power1_average"))) / 1e6 // microwatts
+		}
+		gpus = append(gpus, g)
+	}
+	return gpus
+}
+
+// readNvidiaGPU shells out to nvidia-smi. A missing binary means no NVIDIA
+// driver and is not an error; the exec is bounded by collectTimeout so a hung
+// driver gets killed instead of piling up.
+func readNvidiaGPU() ([]GPUStat, error) {
+	bin, err := exec.LookPath("nvidia-smi")
+	if err != nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin,
+		"--query-gpu=index,name,utilization.gpu,memory.used,memory.total,temperature.gpu",
+		"--format=csv,noheader,nounits").Output()
+	if ctx.Err() != nil {
+		return nil, errors.New("nvidia-smi timed out")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseNvidiaSMI(string(out)), nil
+}
+
+// parseNvidiaSMI parses lines like "0, NVIDIA GeForce RTX 3060, 45, 1024, 12288, 61".
+// Memory is in MiB; unsupported fields come back as "[N/A]" and are left zero.
+func parseNvidiaSMI(out string) []GPUStat {
+	var gpus []GPUStat
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		f := strings.Split(line, ",")
+		if len(f) < 6 {
+			continue
+		}
+		for i := range f {
+			f[i] = strings.TrimSpace(f[i])
+		}
+		num := func(s string) float64 {
+			v, _ := strconv.ParseFloat(s, 64)
+			return v
+		}
+		idx, _ := strconv.Atoi(f[0])
+		gpus = append(gpus, GPUStat{
+			Index:       idx,
+			Vendor:      "nvidia",
+			Name:        f[1],
+			UtilPercent: num(f[2]),
+			MemUsedB:    uint64(num(f[3])) << 20,
+			MemTotalB:   uint64(num(f[4])) << 20,
+			TempC:       num(f[5]),
+		})
+	}
+	return gpus
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// responseHeaders builds the headers added to every response from
+// SYSDASH_HEADERS, one "Name: value" per line, plus SYSDASH_CSP
+// (Content-Security-Policy) and SYSDASH_FRAME_OPTIONS (X-Frame-Options).
+// A CSP is full of semicolons and commas, so lines are the only separator
+// that never needs escaping; the two dedicated variables cover the usual
+// case of embedding the dashboard in another page without a multi-line env.
+func responseHeaders() (http.Header, error) {
+	h := http.Header{}
+	for _, line := range strings.Split(os.Getenv("SYSDASH_HEADERS"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid SYSDASH_HEADERS line %q, want Name: value", line)
+		}
+		h.Add(name, value)
+	}
+	if v := strings.TrimSpace(os.Getenv("SYSDASH_CSP")); v != "" {
+		h.Set("Content-Security-Policy", v)
+	}
+	if v := strings.TrimSpace(os.Getenv("SYSDASH_FRAME_OPTIONS")); v != "" {
+		h.Set("X-Frame-Options", v)
+	}
+	return h, nil
+}
+
+// validHeaderName reports whether s is an RFC 9110 token.
+func validHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// withHeaders sets h on every response before next runs, so a handler can
+// still override one (Content-Type, Cache-Control) for its own responses.
+func withHeaders(h http.Header, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range h {
+			w.Header()[k] = slices.Clone(v)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// healthFactor is one input to HealthScore. Below warn it costs nothing,
+// between warn and crit its penalty rises linearly to 1, and at or above crit
+// it turns the status red on its own.
+type healthFactor struct {
+	weight     float64
+	warn, crit float64
+	value      func(m Metrics) float64
+}
+
+var healthFactors = map[string]*healthFactor{
+	"cpu":  {1, 70, 95, func(m Metrics) float64 { return m.CPUPercent }},
+	"mem":  {1, 80, 95, memUsedPct},
+	"disk": {1, 85, 95, maxDiskUsedPct},
+	// load per core, in percent: 100 means one runnable task per core
+	"load": {1, 100, 200, func(m Metrics) float64 {
+		if m.LoadSource == "psi" {
+			return math.NaN()
+		}
+		if m.CPUCores == 0 {
+			return 0
+		}
+		return m.Load1 / float64(m.CPUCores) * 100
+	}},
+	// with SYSDASH_LOAD_SOURCE=psi, Load1 is the share of time tasks waited
+	// for a CPU (avg10, percent), which takes the place of load
+	"pressure": {1, 20, 50, func(m Metrics) float64 {
+		if m.LoadSource != "psi" {
+			return math.NaN()
+		}
+		return m.Load1
+	}},
+}
+
+func maxDiskUsedPct(m Metrics) float64 {
+	v := 0.0
+	for _, d := range m.Disks {
+		v = math.Max(v, d.UsedPct)
+	}
+	return v
+}
+
+// healthOf computes the 0-100 score (100 = all factors below warn) and a
+// green/amber/red status for the tile in the UI.
+func healthOf(m Metrics) (float64, string) {
+	var sum, weights float64
+	critical := false
+	for _, f := range healthFactors {
+		if f.weight <= 0 {
+			continue
+		}
+		v := f.value(m)
+		if math.IsNaN(v) {
+			// not applicable to this sample (load vs. pressure)
+			continue
+		}
+		pen := 0.0
+		switch {
+		case v >= f.crit:
+			pen, critical = 1, true
+		case v > f.warn:
+			pen = (v - f.warn) / (f.crit - f.warn)
+		}
+		sum += f.weight * pen
+		weights += f.weight
+	}
+	score := 100.0
+	if weights > 0 {
+		score = 100 * (1 - sum/weights)
+	}
+	switch {
+	case critical || score < 50:
+		return score, "red"
+	case score < 90:
+		return score, "amber"
+	}
+	return score, "green"
+}
+
+// loadHealthConfig applies SYSDASH_HEALTH_WEIGHTS ("cpu=2,disk=0.5") and
+// SYSDASH_HEALTH_THRESHOLDS ("cpu=60:90,mem=85:97", warn:crit).
+func loadHealthConfig() error {
+	each := func(key string, fn func(f *healthFactor, v string) error) error {
+		for _, p := range strings.Split(os.Getenv(key), ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			name, v, _ := strings.Cut(p, "=")
+			f, ok := healthFactors[strings.TrimSpace(name)]
+			if !ok {
+				return fmt.Errorf("%s: unknown factor %q (want cpu, mem, disk, load or pressure)", key, name)
+			}
+			if err := fn(f, strings.TrimSpace(v)); err != nil {
+				return fmt.Errorf("%s: %s: %v", key, name, err)
+			}
+		}
+		return nil
+	}
+	err := each("SYSDASH_HEALTH_WEIGHTS", func(f *healthFactor, v string) error {
+		w, err := strconv.ParseFloat(v, 64)
+		if err != nil || w < 0 {
+			return fmt.Errorf("bad weight %q", v)
+		}
+		f.weight = w
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return each("SYSDASH_HEALTH_THRESHOLDS", func(f *healthFactor, v string) error {
+		ws, cs, _ := strings.Cut(v, ":")
+		warn, err1 := strconv.ParseFloat(ws, 64)
+		crit, err2 := strconv.ParseFloat(cs, 64)
+		if err1 != nil || err2 != nil || warn >= crit {
+			return fmt.Errorf("want warn:crit with warn < crit, got %q", v)
+		}
+		f.warn, f.crit = warn, crit
+		return nil
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+)
+
+// /api/history.bin is the history for devices that can't afford a JSON
+// parser. All values are little-endian. The stream is a 12-byte header
+//
+//	0  [4]byte  magic "SDH1"
+//	4  uint16   layout version (1)
+//	6  uint16   record size in bytes (32)
+//	8  uint32   record count
+//
+// followed by one record per sample, oldest first:
+//
+//	0  uint32   timestamp, Unix seconds
+//	4  float32  cpu_percent
+//	8  float32  load1
+//	12 float32  memory used, percent
+//	16 float32  received bytes/s, summed over interfaces
+//	20 float32  transmitted bytes/s, summed over interfaces
+//	24 float32  fullest filesystem, used percent
+//	28 float32  hottest sensor, °C; NaN when there are none
+//
+// Readers should skip by the header's record size, so fields appended in a
+// later version don't break them.
+const (
+	histBinMagic      = "SDH1"
+	histBinVersion    = 1
+	histBinHeaderSize = 12
+	histBinRecordSize = 32
+)
+
+func encodeHistoryBin(h []Metrics) []byte {
+	b := make([]byte, histBinHeaderSize, histBinHeaderSize+len(h)*histBinRecordSize)
+	copy(b, histBinMagic)
+	le := binary.LittleEndian
+	le.PutUint16(b[4:], histBinVersion)
+	le.PutUint16(b[6:], histBinRecordSize)
+	le.PutUint32(b[8:], uint32(len(h)))
+	f32 := func(v float64) uint32 { return math.Float32bits(float32(v)) }
+	for _, m := range h {
+		var rx, tx, disk float64
+		for _, n := range m.Net {
+			rx += n.RxBps
+			tx += n.TxBps
+		}
+		for _, d := range m.Disks {
+			disk = max(disk, d.UsedPct)
+		}
+		temp := math.NaN()
+		for _, t := range m.Temps {
+			if math.IsNaN(temp) || t.C > temp {
+				temp = t.C
+			}
+		}
+		b = le.AppendUint32(b, uint32(m.Timestamp.Unix()))
+		for _, v := range []float64{m.CPUPercent, m.Load1, memUsedPct(m), rx, tx, disk, temp} {
+			b = le.AppendUint32(b, f32(v))
+		}
+	}
+	return b
+}
+
+func writeHistoryBin(w http.ResponseWriter, h []Metrics) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := w.Write(encodeHistoryBin(h)); err != nil {
+		logErr(err, "[history.bin] write error")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// humanBytes formats n with binary units: 512 B, 1.5 KiB, 15.6 GiB.
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// humanizeJSON adds a readable sibling next to every byte-valued field of an
+// encoded sample, at any depth: "mem_total_bytes" gains "mem_total_human" and
+// "rx_bps" gains "rx_bps_human" ("1.2 MiB/s"). Working on the JSON keeps the
+// rule in one place instead of a parallel set of struct fields.
+func humanizeJSON(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	humanize(v)
+	return marshalJSON(v)
+}
+
+func humanize(v any) {
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			humanize(e)
+		}
+	case map[string]any:
+		for k, e := range v {
+			n, ok := e.(json.Number)
+			if !ok {
+				humanize(e)
+				continue
+			}
+			f, err := n.Float64()
+			if err != nil || f < 0 {
+				continue
+			}
+			if base, ok := strings.CutSuffix(k, "_bytes"); ok {
+				v[base+"_human"] = humanBytes(uint64(f))
+			} else if strings.HasSuffix(k, "_bps") {
+				v[k+"_human"] = humanBytes(uint64(f)) + "/s"
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// jsonLogs is set by SYSDASH_LOG_FORMAT=json. The daemon keeps logging with
+// log.Printf; setupLogging routes that output through a slog JSON handler so
+// every line becomes one object with time, level and msg. Lines that carry
+// an error or end the process go through logErr and fatalErr instead, which
+// jsonLog turns into an "error" attr and the ERROR level.
+var (
+	jsonLogs bool
+	jsonLog  slogWriter
+)
+
+// Bracketed components that log routine events rather than problems.
+var infoComponents = map[string]bool{"access": true, "aggregate": true}
+
+func setupLogging() {
+	switch v := strings.ToLower(os.Getenv("SYSDASH_LOG_FORMAT")); v {
+	case "", "text":
+	case "json":
+		jsonLogs = true
+		h := slog.NewJSONHandler(os.Stderr, nil)
+		slog.SetDefault(slog.New(h))
+		// replaces the writer SetDefault installed, which would log
+		// everything at INFO with the "[component]" still in the message
+		jsonLog = slogWriter{h}
+		log.SetFlags(0)
+		log.SetOutput(jsonLog)
+	default:
+		log.Printf("ignoring unknown SYSDASH_LOG_FORMAT=%q, using text", v)
+	}
+}
+
+// slogWriter turns one log.Printf line into a slog record. The repo's
+// "[component] message" convention becomes a component field, and such lines
+// are warnings: they are how readers and writers report failures. So are the
+// "invalid ..." and "ignoring ..." lines about configuration.
+type slogWriter struct{ h slog.Handler }
+
+func (w slogWriter) Write(p []byte) (int, error) {
+	return len(p), w.handle(slog.LevelInfo, strings.TrimSuffix(string(p), "\n"), nil)
+}
+
+// handle logs msg at level, or higher when its component or wording makes it
+// a warning, with err as an "error" attr.
+func (w slogWriter) handle(level slog.Level, msg string, err error) error {
+	var attrs []slog.Attr
+	if rest, ok := strings.CutPrefix(msg, "["); ok {
+		if comp, m, ok := strings.Cut(rest, "] "); ok && !strings.ContainsAny(comp, " ]") {
+			msg = m
+			attrs = append(attrs, slog.String("component", comp))
+			if !infoComponents[comp] {
+				level = max(level, slog.LevelWarn)
+			}
+		}
+	}
+	if strings.HasPrefix(msg, "invalid ") || strings.HasPrefix(msg, "ignoring ") {
+		level = max(level, slog.LevelWarn)
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	r.AddAttrs(attrs...)
+	return w.h.Handle(context.Background(), r)
+}
+
+// logErr is log.Printf for a line about err: "msg: err" as text, msg with an
+// "error" attr as JSON. An empty format logs err on its own.
+func logErr(err error, format string, args ...any) {
+	logAt(slog.LevelInfo, err, format, args)
+}
+
+// fatalErr is logErr at ERROR followed by exit 1; err may be nil.
+func fatalErr(err error, format string, args ...any) {
+	logAt(slog.LevelError, err, format, args)
+	os.Exit(1)
+}
+
+// fatalf is log.Fatalf, logged at ERROR.
+func fatalf(format string, args ...any) {
+	fatalErr(nil, format, args...)
+}
+
+func logAt(level slog.Level, err error, format string, args []any) {
+	msg := fmt.Sprintf(format, args...)
+	if format == "" && err != nil {
+		msg = err.Error()
+	}
+	if jsonLogs {
+		jsonLog.handle(level, msg, err)
+		return
+	}
+	if format != "" && err != nil {
+		msg += ": " + err.Error()
+	}
+	log.Print(msg)
+}
@@ -2,22 +2,37 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"embed"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"log/slog"
+	"maps"
 	"math"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -28,38 +43,154 @@ var webFS embed.FS
 type CPUTimes struct{ User, Nice, System, Idle, IOWait, IRQ, SoftIRQ, Steal, Guest, GuestNice uint64 }
 
 type NetStat struct {
-	Name     string `json:"name"`
-	RxBytes  uint64 `json:"rx_bytes"`
-	TxBytes  uint64 `json:"tx_bytes"`
-	RxPkts   uint64 `json:"rx_packets"`
-	TxPkts   uint64 `json:"tx_packets"`
-	OperUp   bool   `json:"oper_up"`
-	AddrIPv4 string `json:"addr_ipv4,omitempty"`
+	Name            string    `json:"name"`
+	DisplayName     string    `json:"display_name"`
+	RxBytes         uint64    `json:"rx_bytes"`
+	TxBytes         uint64    `json:"tx_bytes"`
+	RxPkts          uint64    `json:"rx_packets"`
+	TxPkts          uint64    `json:"tx_packets"`
+	OperUp          bool      `json:"oper_up"`
+	AddrIPv4        string    `json:"addr_ipv4,omitempty"`
+	AddrsIPv6       []string  `json:"addrs_ipv6,omitempty"`
+	MAC             string    `json:"mac,omitempty"`
+	SpeedMbps       int       `json:"speed_mbps"`
+	MTU             int       `json:"mtu"`
+	RxErrors        uint64    `json:"rx_errors"`
+	TxErrors        uint64    `json:"tx_errors"`
+	RxDropped       uint64    `json:"rx_dropped"`
+	TxDropped       uint64    `json:"tx_dropped"`
+	RxBps           float64   `json:"rx_bps"`
+	TxBps           float64   `json:"tx_bps"`
+	RxErrorsPerSec  float64   `json:"rx_errors_per_sec"`
+	TxErrorsPerSec  float64   `json:"tx_errors_per_sec"`
+	RxDroppedPerSec float64   `json:"rx_dropped_per_sec"`
+	TxDroppedPerSec float64   `json:"tx_dropped_per_sec"`
+	CarrierChanges  uint64    `json:"carrier_changes"`
+	FlapCount       int       `json:"flap_count"`
+	LastChange      time.Time `json:"last_change,omitzero"`
 }
 
 type Temp struct {
-	Sensor string  `json:"sensor"`
-	C      float64 `json:"celsius"`
+	Sensor       string  `json:"sensor"`
+	Label        string  `json:"label,omitempty"`
+	FriendlyName string  `json:"friendly_name"`
+	C            float64 `json:"celsius"`
+}
+
+type DiskStat struct {
+	Mount         string  `json:"mount"`
+	Device        string  `json:"device"`
+	FSType        string  `json:"fstype"`
+	TotalB        uint64  `json:"total_bytes"`
+	FreeB         uint64  `json:"free_bytes"`
+	AvailB        uint64  `json:"avail_bytes"`
+	UsedPct       float64 `json:"used_pct"`
+	InodesTotal   uint64  `json:"inodes_total"`
+	InodesFree    uint64  `json:"inodes_free"`
+	InodesUsedPct float64 `json:"inodes_used_pct"`
+	ReadOnly      bool    `json:"read_only"`
+}
+
+type PSILine struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+}
+
+type PSIResource struct {
+	Some PSILine  `json:"some"`
+	Full *PSILine `json:"full,omitempty"`
+}
+
+type PSI struct {
+	CPU    PSIResource `json:"cpu"`
+	Memory PSIResource `json:"memory"`
+	IO     PSIResource `json:"io"`
+}
+
+// SelfStat is sysdash's own footprint, for keeping an eye on it on small boards.
+type SelfStat struct {
+	Goroutines   int     `json:"goroutines"`
+	HeapAllocB   uint64  `json:"heap_alloc_bytes"`
+	SysB         uint64  `json:"sys_bytes"`
+	CollectionMs float64 `json:"collection_ms"`
+	// times superviseCollect had to restart collectLoop
+	CollectRestarts uint64 `json:"collect_restarts"`
 }
 
 type Metrics struct {
-	Timestamp  time.Time  `json:"timestamp"`
-	Hostname   string     `json:"hostname"`
-	OS         string     `json:"os"`
-	Kernel     string     `json:"kernel"`
-	UptimeSec  uint64     `json:"uptime_sec"`
-	Load1      float64    `json:"load1"`
-	Load5      float64    `json:"load5"`
-	Load15     float64    `json:"load15"`
-	CPUPercent float64    `json:"cpu_percent"`
-	CPUCores   int        `json:"cpu_cores"`
-	MemTotalB  uint64     `json:"mem_total_bytes"`
-	MemAvailB  uint64     `json:"mem_available_bytes"`
-	SwapTotalB uint64     `json:"swap_total_bytes"`
-	SwapFreeB  uint64     `json:"swap_free_bytes"`
-	Net        []NetStat  `json:"net"`
-	Temps      []Temp     `json:"temps"`
-	LastError  string     `json:"last_error,omitempty"`
+	Timestamp             time.Time      `json:"timestamp"`
+	Hostname              string         `json:"hostname"`
+	OS                    string         `json:"os"`
+	Kernel                string         `json:"kernel"`
+	UptimeSec             uint64         `json:"uptime_sec"`
+	Load1                 float64        `json:"load1"`
+	Load5                 float64        `json:"load5"`
+	Load15                float64        `json:"load15"`
+	LoadSource            string         `json:"load_source"`
+	CPUPercent            float64        `json:"cpu_percent"`
+	CPUPercentSmoothed    float64        `json:"cpu_percent_smoothed,omitempty"`
+	CPUIOWaitPercent      float64        `json:"cpu_iowait_percent"`
+	CPUStealPercent       float64        `json:"cpu_steal_percent"`
+	CPUCores              int            `json:"cpu_cores"`
+	ContextSwitchesPerSec float64        `json:"context_switches_per_sec"`
+	InterruptsPerSec      float64        `json:"interrupts_per_sec"`
+	ForksPerSec           float64        `json:"forks_per_sec"`
+	ProcsRunning          int            `json:"procs_running"`
+	ProcsBlocked          int            `json:"procs_blocked"`
+	Cores                 []CoreStat     `json:"cores,omitempty"`
+	MemTotalB             uint64         `json:"mem_total_bytes"`
+	MemAvailB             uint64         `json:"mem_available_bytes"`
+	MemAvailEstimated     bool           `json:"mem_available_estimated,omitempty"`
+	MemCgroup             bool           `json:"mem_cgroup,omitempty"`
+	MemDetail             MemDetail      `json:"mem_detail"`
+	SwapTotalB            uint64         `json:"swap_total_bytes"`
+	SwapFreeB             uint64         `json:"swap_free_bytes"`
+	Net                   []NetStat      `json:"net"`
+	UplinkRxBps           float64        `json:"uplink_rx_bps,omitempty"`
+	UplinkTxBps           float64        `json:"uplink_tx_bps,omitempty"`
+	Connections           map[string]int `json:"connections,omitempty"`
+	ConnectionsV6         map[string]int `json:"connections_v6,omitempty"`
+	Temps                 []Temp         `json:"temps"`
+	Disks                 []DiskStat     `json:"disks"`
+	DiskIO                []DiskIOStat   `json:"disk_io,omitempty"`
+	DiskTemps             []DiskTemp     `json:"disk_temps,omitempty"`
+	RAIDArrays            []RAIDStat     `json:"raid_arrays,omitempty"`
+	PSI                   *PSI           `json:"psi,omitempty"`
+	CollectionDurationMs  float64        `json:"collection_duration_ms"`
+	HealthScore           float64        `json:"health_score"`
+	HealthStatus          string         `json:"health_status"`
+	Alerts                []Alert        `json:"alerts,omitempty"`
+	WarmingUp             bool           `json:"warming_up,omitempty"`
+	EntropyAvail          int            `json:"entropy_avail"`
+	FDAllocated           uint64         `json:"fd_allocated"`
+	FDMax                 uint64         `json:"fd_max"`
+	GPUs                  []GPUStat      `json:"gpus,omitempty"`
+	Processes             []ProcStat     `json:"processes,omitempty"`
+	WatchedProcesses      []WatchedProc  `json:"watched_processes,omitempty"`
+	ProcessCount          int            `json:"process_count"`
+	ThreadCount           int            `json:"thread_count"`
+	ZombieCount           int            `json:"zombie_count"`
+	LastError             string         `json:"last_error,omitempty"`
+	PushFailures          uint64         `json:"push_failures,omitempty"`
+	WriteFailures         uint64         `json:"write_failures,omitempty"`
+	Self                  SelfStat       `json:"self"`
+}
+
+type Stat struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+type Summary struct {
+	Samples    int       `json:"samples"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	WindowSec  float64   `json:"window_sec"`
+	CPUPercent Stat      `json:"cpu_percent"`
+	Load1      Stat      `json:"load1"`
+	MemUsedPct Stat      `json:"mem_used_pct"`
 }
 
 var (
@@ -69,16 +200,140 @@ var (
 	outDir      = "/var/lib/sysdash"
 	outFile     = "metrics.json"
 	sampleEvery = 2 * time.Second
+	procRoot    = "/proc"
+	sysRoot     = "/sys"
+
+	// marshalled forms of current/history, refreshed once per sample (guarded by mtx)
+	currentJSON []byte
+	currentETag string
+	historyJSON []byte
+
+	// fsync metrics.json and its directory on every write (SYSDASH_DURABLE_WRITE)
+	durableWrite bool
+	// consecutive writeJSON failures, see recordWrite
+	writeMtx      sync.Mutex
+	writeFailures uint64
+	writeErr      error
+	// history is capped by count unless SYSDASH_HISTORY_DURATION switches it to age
+	historyLen      = 120
+	historyDuration time.Duration
+	// most samples one /api/history response may carry (0 = no limit)
+	historyMaxResponse int
+	// per-reader deadline inside collectLoop
+	collectTimeout = time.Second
+	// gap between the baseline /proc/stat read and the first sample, so the
+	// first CPU percent covers a real interval instead of a few microseconds
+	cpuWarmup = 500 * time.Millisecond
+	// SYSDASH_TZ: zone sample timestamps are expressed in (RFC 3339 with offset)
+	tz = time.Local
+	// SYSDASH_NO_FILE / -no-file: skip writeJSON entirely (read-only root, SD cards)
+	noFile bool
+	// SYSDASH_COMPACT_JSON drops the indentation from API and file output
+	compactJSON bool
+	// set once the first sample after the warmup is published; backs /readyz
+	ready atomic.Bool
+	// SYSDASH_WARMUP: /readyz stays 503 until warmupUntil; with
+	// SYSDASH_WARMUP_DISCARD the samples before it are not kept in history
+	warmup        time.Duration
+	warmupDiscard bool
+	warmupUntil   time.Time
+	// SYSDASH_LOAD_SOURCE: "loadavg" (default) or "psi" for CPU pressure averages
+	loadSource = "loadavg"
+	// SYSDASH_CPU_EMA: smoothing factor in (0,1] for CPUPercentSmoothed; 0 is off
+	cpuEMAAlpha float64
+	// SYSDASH_JITTER: random ± offset on each sleep so a fleet doesn't push in lockstep
+	sampleJitter time.Duration
+	// pause before retrying a transient /proc read error
+	readRetryDelay = 5 * time.Millisecond
+	// loopback and container/VM bridges are hidden unless SYSDASH_NET_INCLUDE_VIRTUAL=1
+	netIncludeVirtual    bool
+	virtualIfacePrefixes = []string{"veth", "docker", "br-", "virbr"}
+	// SYSDASH_NET_FILTER: when set, exactly the interfaces it matches are
+	// reported and the loopback/virtual exclusion no longer applies
+	netFilter *regexp.Regexp
+	// SYSDASH_BIND_DEVICE: interface the listening socket is tied to
+	bindDevice string
+	// SYSDASH_NET_ALIAS: raw interface name -> display name
+	netAlias map[string]string
+	// SYSDASH_UPLINK_IFACE: interface whose rates are surfaced as UplinkRx/TxBps;
+	// never hidden by the virtual-interface filter
+	uplinkIface string
+	// mountpoints pinned via SYSDASH_DISK_MOUNTS; empty means auto-detect
+	diskMounts []string
+	// sensor type substrings to keep / drop in readTemps
+	tempInclude, tempExclude []string
 )
 
 func must(err error) {
 	if err != nil {
-		log.Fatal(err)
+		fatalErr(err, "")
 	}
 }
 
-func readFile(path string) (string, error) {
+// envBool reports whether an env var is set to a truthy value (1, true, yes).
+func envBool(key string) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// procPath and sysPath resolve kernel files under procRoot/sysRoot, so tests
+// (and containers with a remapped /proc) can point the readers elsewhere.
+func procPath(elem ...string) string {
+	return filepath.Join(append([]string{procRoot}, elem...)...)
+}
+
+func sysPath(elem ...string) string {
+	return filepath.Join(append([]string{sysRoot}, elem...)...)
+}
+
+// envDuration parses a duration env var, falling back to def when it is unset
+// or invalid. Zero is allowed and means "no timeout" for the server settings.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// envList splits a comma-separated env var into trimmed, lowercased, non-empty items.
+func envList(key string) []string {
+	var out []string
+	for _, p := range strings.Split(os.Getenv(key), ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// transientReadErr reports whether a failed read is worth one more try. procfs
+// occasionally returns EINVAL/EAGAIN or a short read while the kernel is
+// updating a file; missing files and permission errors won't fix themselves.
+func transientReadErr(err error) bool {
+	return err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission)
+}
+
+// readRetry is os.ReadFile with a single quick retry on transient errors.
+func readRetry(path string) ([]byte, error) {
 	b, err := os.ReadFile(path)
+	if transientReadErr(err) {
+		time.Sleep(readRetryDelay)
+		b, err = os.ReadFile(path)
+	}
+	return b, err
+}
+
+func readFile(path string) (string, error) {
+	b, err := readRetry(path)
 	if err != nil {
 		return "", err
 	}
@@ -86,34 +341,103 @@ func readFile(path string) (string, error) {
 }
 
 func parseCPUTimes() (CPUTimes, error) {
-	f, err := os.Open("/proc/stat")
+	cs, err := readCPUStat()
+	return cs.total, err
+}
+
+// cpuStat is everything sample() uses from /proc/stat.
+type cpuStat struct {
+	total  CPUTimes
+	perCPU map[int]CPUTimes // "cpuN" lines keyed by N; offline CPUs have none
+	// cumulative counts since boot: context switches, interrupts, forks
+	ctxt, intr, forks uint64
+	// instantaneous: runnable tasks, tasks blocked on I/O
+	running, blocked int
+}
+
+func readCPUStat() (cpuStat, error) {
+	b, err := readRetry(procPath("stat"))
 	if err != nil {
-		return CPUTimes{}, err
+		return cpuStat{}, err
 	}
-	defer f.Close()
-	sc := bufio.NewScanner(f)
+	cs := cpuStat{perCPU: map[int]CPUTimes{}}
+	found := false
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	// the intr line has one column per IRQ and can exceed the default 64K
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	for sc.Scan() {
 		fields := strings.Fields(sc.Text())
-		if len(fields) > 0 && fields[0] == "cpu" {
-			// cpu  user nice system idle iowait irq softirq steal guest guest_nice
-			get := func(i int) uint64 {
-				if i >= len(fields) {
-					return 0
-				}
-				v, _ := strconv.ParseUint(fields[i], 10, 64)
-				return v
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "ctxt":
+			cs.ctxt, _ = strconv.ParseUint(fields[1], 10, 64)
+			continue
+		case "intr":
+			// first column is the total, the rest are per IRQ
+			cs.intr, _ = strconv.ParseUint(fields[1], 10, 64)
+			continue
+		case "processes":
+			cs.forks, _ = strconv.ParseUint(fields[1], 10, 64)
+			continue
+		case "procs_running":
+			cs.running, _ = strconv.Atoi(fields[1])
+			continue
+		case "procs_blocked":
+			cs.blocked, _ = strconv.Atoi(fields[1])
+			continue
+		}
+		if !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		// cpu  user nice system idle iowait irq softirq steal guest guest_nice
+		get := func(i int) uint64 {
+			if i >= len(fields) {
+				return 0
 			}
-			return CPUTimes{
-				User: get(1), Nice: get(2), System: get(3), Idle: get(4),
-				IOWait: get(5), IRQ: get(6), SoftIRQ: get(7), Steal: get(8),
-				Guest: get(9), GuestNice: get(10),
-			}, nil
+			v, _ := strconv.ParseUint(fields[i], 10, 64)
+			return v
+		}
+		t := CPUTimes{
+			User: get(1), Nice: get(2), System: get(3), Idle: get(4),
+			IOWait: get(5), IRQ: get(6), SoftIRQ: get(7), Steal: get(8),
+			Guest: get(9), GuestNice: get(10),
 		}
+		if fields[0] == "cpu" {
+			cs.total, found = t, true
+		} else if n, err := strconv.Atoi(fields[0][3:]); err == nil {
+			cs.perCPU[n] = t
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return cpuStat{}, err
 	}
-	return CPUTimes{}, errors.New("cpu line not found")
+	if !found {
+		return cpuStat{}, errors.New("cpu line not found")
+	}
+	return cs, nil
 }
 
-func cpuPercent(prev, cur CPUTimes) float64 {
+// CPUBreakdown splits one interval's CPU time into percentages. Busy is
+// everything but idle and iowait, matching the historical cpu_percent.
+type CPUBreakdown struct {
+	User, System, IOWait, Steal, Idle, Busy float64
+}
+
+// counterReset reports whether any counter went backwards between samples,
+// which happens on CPU hotplug or VM snapshot restore. The uint64 deltas
+// would underflow into a huge bogus percentage.
+func counterReset(prev, cur CPUTimes) bool {
+	return cur.User < prev.User || cur.Nice < prev.Nice || cur.System < prev.System ||
+		cur.Idle < prev.Idle || cur.IOWait < prev.IOWait || cur.IRQ < prev.IRQ ||
+		cur.SoftIRQ < prev.SoftIRQ || cur.Steal < prev.Steal
+}
+
+func cpuBreakdown(prev, cur CPUTimes) CPUBreakdown {
+	if counterReset(prev, cur) {
+		return CPUBreakdown{}
+	}
 	idlePrev := prev.Idle + prev.IOWait
 	idleCur := cur.Idle + cur.IOWait
 	nonPrev := prev.User + prev.Nice + prev.System + prev.IRQ + prev.SoftIRQ + prev.Steal
@@ -122,19 +446,47 @@ func cpuPercent(prev, cur CPUTimes) float64 {
 	nonDelta := float64(nonCur - nonPrev)
 	total := idleDelta + nonDelta
 	if total <= 0 {
-		return 0
+		return CPUBreakdown{}
 	}
-	return math.Max(0, math.Min(100, (nonDelta/total)*100))
+	pct := func(d float64) float64 { return math.Max(0, math.Min(100, d/total*100)) }
+	return CPUBreakdown{
+		User:   pct(float64((cur.User + cur.Nice) - (prev.User + prev.Nice))),
+		System: pct(float64((cur.System + cur.IRQ + cur.SoftIRQ) - (prev.System + prev.IRQ + prev.SoftIRQ))),
+		IOWait: pct(float64(cur.IOWait - prev.IOWait)),
+		Steal:  pct(float64(cur.Steal - prev.Steal)),
+		Idle:   pct(float64(cur.Idle - prev.Idle)),
+		Busy:   pct(nonDelta),
+	}
+}
+
+func cpuPercent(prev, cur CPUTimes) float64 {
+	return cpuBreakdown(prev, cur).Busy
+}
+
+// errMemEstimated is returned by readMem, together with valid values, on
+// kernels older than 3.14 whose meminfo has no MemAvailable.
+var errMemEstimated = errors.New("MemAvailable missing, estimated from MemFree+Buffers+Cached")
+
+// MemDetail breaks down memory that MemAvailable doesn't explain: kernel slab
+// caches and reserved hugepages.
+type MemDetail struct {
+	SlabB            uint64 `json:"slab_bytes"`
+	SlabReclaimableB uint64 `json:"slab_reclaimable_bytes"`
+	SlabUnreclaimB   uint64 `json:"slab_unreclaimable_bytes"`
+	HugePagesTotal   uint64 `json:"hugepages_total"`
+	HugePagesFree    uint64 `json:"hugepages_free"`
+	HugePageSizeB    uint64 `json:"hugepage_size_bytes"`
 }
 
-func readMem() (total, avail, swapT, swapF uint64, err error) {
-	f, e := os.Open("/proc/meminfo")
+func readMem() (total, avail, swapT, swapF uint64, detail MemDetail, err error) {
+	b, e := readRetry(procPath("meminfo"))
 	if e != nil {
 		err = e
 		return
 	}
-	defer f.Close()
-	sc := bufio.NewScanner(f)
+	hasAvail := false
+	var free, buffers, cached uint64
+	sc := bufio.NewScanner(bytes.NewReader(b))
 	for sc.Scan() {
 		var key, unit string
 		var val uint64
@@ -143,18 +495,42 @@ func readMem() (total, avail, swapT, swapF uint64, err error) {
 		case "MemTotal":
 			total = val * 1024
 		case "MemAvailable":
-			avail = val * 1024
+			avail, hasAvail = val*1024, true
+		case "MemFree":
+			free = val * 1024
+		case "Buffers":
+			buffers = val * 1024
+		case "Cached":
+			cached = val * 1024
 		case "SwapTotal":
 			swapT = val * 1024
 		case "SwapFree":
 			swapF = val * 1024
+		case "Slab":
+			detail.SlabB = val * 1024
+		case "SReclaimable":
+			detail.SlabReclaimableB = val * 1024
+		case "SUnreclaim":
+			detail.SlabUnreclaimB = val * 1024
+		case "HugePages_Total":
+			detail.HugePagesTotal = val // a page count, no unit
+		case "HugePages_Free":
+			detail.HugePagesFree = val
+		case "Hugepagesize":
+			detail.HugePageSizeB = val * 1024
 		}
 	}
+	if !hasAvail && total > 0 {
+		// overestimates a little: part of Cached (shmem, dirty pages) can't be
+		// reclaimed, but it beats reporting 100% used
+		avail = min(free+buffers+cached, total)
+		err = errMemEstimated
+	}
 	return
 }
 
 func readLoad() (l1, l5, l15 float64, err error) {
-	s, e := readFile("/proc/loadavg")
+	s, e := readFile(procPath("loadavg"))
 	if e != nil {
 		return 0, 0, 0, e
 	}
@@ -169,7 +545,7 @@ func readLoad() (l1, l5, l15 float64, err error) {
 }
 
 func readUptime() (uint64, error) {
-	s, e := readFile("/proc/uptime")
+	s, e := readFile(procPath("uptime"))
 	if e != nil {
 		return 0, e
 	}
@@ -181,6 +557,91 @@ func readUptime() (uint64, error) {
 	return uint64(up), nil
 }
 
+func parsePSIFile(path string) (PSIResource, error) {
+	var res PSIResource
+	s, err := readFile(path)
+	if err != nil {
+		return res, err
+	}
+	// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var pl PSILine
+		for _, kv := range fields[1:] {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			f, _ := strconv.ParseFloat(v, 64)
+			switch k {
+			case "avg10":
+				pl.Avg10 = f
+			case "avg60":
+				pl.Avg60 = f
+			case "avg300":
+				pl.Avg300 = f
+			}
+		}
+		switch fields[0] {
+		case "some":
+			res.Some = pl
+		case "full":
+			res.Full = &pl
+		}
+	}
+	return res, nil
+}
+
+// readPSI returns nil without error when the kernel has no PSI support
+// (pre-4.20, or booted with psi=0, where reads fail with EOPNOTSUPP).
+func readPSI() (*PSI, error) {
+	var p PSI
+	for _, r := range []struct {
+		name string
+		dst  *PSIResource
+	}{{"cpu", &p.CPU}, {"memory", &p.Memory}, {"io", &p.IO}} {
+		res, err := parsePSIFile(procPath("pressure", r.name))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EOPNOTSUPP) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		*r.dst = res
+	}
+	return &p, nil
+}
+
+type kernelMisc struct {
+	entropy        int
+	fdAlloc, fdMax uint64
+}
+
+// readKernelMisc reads the available entropy and the system-wide file handle
+// usage (file-nr: allocated, unused, max).
+func readKernelMisc() (kernelMisc, error) {
+	var k kernelMisc
+	s, err := readFile(procPath("sys/kernel/random/entropy_avail"))
+	if err != nil {
+		return k, err
+	}
+	k.entropy, _ = strconv.Atoi(s)
+	s, err = readFile(procPath("sys/fs/file-nr"))
+	if err != nil {
+		return k, err
+	}
+	f := strings.Fields(s)
+	if len(f) < 3 {
+		return k, errors.New("bad file-nr")
+	}
+	k.fdAlloc, _ = strconv.ParseUint(f[0], 10, 64)
+	k.fdMax, _ = strconv.ParseUint(f[2], 10, 64)
+	return k, nil
+}
+
 func readKernel() string {
 	uts := syscall.Utsname{}
 	if err := syscall.Uname(&uts); err != nil {
@@ -209,20 +670,73 @@ func readUint(path string) uint64 {
 	return v
 }
 
-func readNet() []NetStat {
-	var out []NetStat
+// counterRate turns two readings of a monotonic counter into a per-second
+// rate; a counter that went backwards (driver reload, iface recreated) yields 0.
+func counterRate(prev, cur uint64, elapsed float64) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / elapsed
+}
 
+// applyNetRates fills in the per-second fields of cur from the previous sample.
+func applyNetRates(cur []NetStat, prev map[string]NetStat, elapsed float64) {
+	for i := range cur {
+		n := &cur[i]
+		p, ok := prev[n.Name]
+		if !ok {
+			continue
+		}
+		n.RxBps = counterRate(p.RxBytes, n.RxBytes, elapsed)
+		n.TxBps = counterRate(p.TxBytes, n.TxBytes, elapsed)
+		n.RxErrorsPerSec = counterRate(p.RxErrors, n.RxErrors, elapsed)
+		n.TxErrorsPerSec = counterRate(p.TxErrors, n.TxErrors, elapsed)
+		n.RxDroppedPerSec = counterRate(p.RxDropped, n.RxDropped, elapsed)
+		n.TxDroppedPerSec = counterRate(p.TxDropped, n.TxDropped, elapsed)
+	}
+}
+
+func skipIface(ifc net.Interface) bool {
+	if ifc.Name == uplinkIface {
+		return false
+	}
+	if netFilter != nil {
+		return !netFilter.MatchString(ifc.Name)
+	}
+	if netIncludeVirtual {
+		return false
+	}
+	if ifc.Flags&net.FlagLoopback != 0 {
+		return true
+	}
+	for _, p := range virtualIfacePrefixes {
+		if strings.HasPrefix(ifc.Name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func readNet() []NetStat {
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		log.Printf("[readNet] net.Interfaces error: %v", err)
-		return out
+		logErr(err, "[readNet] net.Interfaces error")
+		return nil
 	}
+	return readNetIfaces(ifaces)
+}
 
+// readNetIfaces fills in sysfs state and counters for the given interfaces.
+func readNetIfaces(ifaces []net.Interface) []NetStat {
+	var out []NetStat
 	for _, ifc := range ifaces {
+		if skipIface(ifc) {
+			continue
+		}
 		name := ifc.Name
 
 		// operstate from sysfs, with safe fallback to net.Flags
-		opPath := filepath.Join("/sys/class/net", name, "operstate")
+		opPath := sysPath("class/net", name, "operstate")
 		state := "unknown"
 		if b, err := os.ReadFile(opPath); err == nil {
 			state = strings.TrimSpace(string(b))
@@ -233,195 +747,2053 @@ func readNet() []NetStat {
 		}
 
 		// stats from sysfs
-		base := filepath.Join("/sys/class/net", name, "statistics")
+		base := sysPath("class/net", name, "statistics")
 		rxB := readUint(filepath.Join(base, "rx_bytes"))
 		rxP := readUint(filepath.Join(base, "rx_packets"))
 		txB := readUint(filepath.Join(base, "tx_bytes"))
 		txP := readUint(filepath.Join(base, "tx_packets"))
 
-		// IPv4 address
+		// link speed; virtual interfaces report -1 or fail the read entirely
+		speed := 0
+		if s, err := readFile(sysPath("class/net", name, "speed")); err == nil {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				speed = v
+			}
+		}
+
+		// first IPv4 address, all IPv6 addresses
 		var ipv4 string
+		var ipv6, linkLocal6 []string
 		if addrs, _ := ifc.Addrs(); addrs != nil {
 			for _, a := range addrs {
-				if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
-					ipv4 = ipnet.IP.String()
-					break
+				ipnet, ok := a.(*net.IPNet)
+				if !ok {
+					continue
+				}
+				switch {
+				case ipnet.IP.To4() != nil:
+					if ipv4 == "" {
+						ipv4 = ipnet.IP.String()
+					}
+				case ipnet.IP.IsLinkLocalUnicast():
+					linkLocal6 = append(linkLocal6, ipnet.IP.String())
+				default:
+					ipv6 = append(ipv6, ipnet.IP.String())
 				}
 			}
 		}
+		if len(ipv6) == 0 {
+			ipv6 = linkLocal6
+		}
+
+		display := name
+		if a, ok := netAlias[name]; ok {
+			display = a
+		}
 
 		out = append(out, NetStat{
-			Name:     name,
-			RxBytes:  rxB,
-			TxBytes:  txB,
-			RxPkts:   rxP,
-			TxPkts:   txP,
-			OperUp:   operUp,
-			AddrIPv4: ipv4,
+			Name:        name,
+			DisplayName: display,
+			RxBytes:     rxB,
+			TxBytes:     txB,
+			RxPkts:      rxP,
+			TxPkts:      txP,
+			OperUp:      operUp,
+			AddrIPv4:    ipv4,
+			AddrsIPv6:   ipv6,
+			MAC:         ifc.HardwareAddr.String(),
+			SpeedMbps:   speed,
+			MTU:         ifc.MTU,
+			RxErrors:    readUint(filepath.Join(base, "rx_errors")),
+			TxErrors:    readUint(filepath.Join(base, "tx_errors")),
+			RxDropped:   readUint(filepath.Join(base, "rx_dropped")),
+			TxDropped:   readUint(filepath.Join(base, "tx_dropped")),
+			// counts both down and up transitions, including ones between samples
+			CarrierChanges: readUint(sysPath("class/net", name, "carrier_changes")),
 		})
 	}
 
 	return out
 }
 
+// tempAllowed applies SYSDASH_TEMP_INCLUDE / SYSDASH_TEMP_EXCLUDE, both
+// case-insensitive substring lists matched against the sensor type.
+func tempAllowed(sensor string) bool {
+	s := strings.ToLower(sensor)
+	if len(tempInclude) > 0 {
+		ok := false
+		for _, p := range tempInclude {
+			if strings.Contains(s, p) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, p := range tempExclude {
+		if strings.Contains(s, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// hwmon chips whose temp*_label maps readings to individual cores
+var cpuHwmonNames = map[string]bool{"coretemp": true, "k10temp": true, "zenpower": true}
+
 func readTemps() []Temp {
 	var out []Temp
-	_ = filepath.WalkDir("/sys/class/thermal", func(path string, d fs.DirEntry, err error) error {
-		if err != nil || !d.IsDir() || !strings.HasPrefix(filepath.Base(path), "thermal_zone") {
-			return nil
-		}
+	// thermal_zone* entries are symlinks, so glob rather than WalkDir (which won't follow them)
+	zones, _ := filepath.Glob(sysPath("class/thermal/thermal_zone*"))
+	for _, path := range zones {
 		typePath := filepath.Join(path, "type")
 		tempPath := filepath.Join(path, "temp")
 		typ, e1 := os.ReadFile(typePath)
 		val, e2 := os.ReadFile(tempPath)
 		if e1 == nil && e2 == nil {
 			raw := strings.TrimSpace(string(val))
-			f, _ := strconv.ParseFloat(raw, 64)
-			// many drivers report millidegC; fallback if it looks like plain C
-			if f > 200 {
-				f = f / 1000.0
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			// thermal zone temp is millidegrees Celsius per the sysfs ABI
+			f /= 1000
+			sensor := strings.TrimSpace(string(typ))
+			if !tempAllowed(sensor) {
+				continue
 			}
-			out = append(out, Temp{Sensor: strings.TrimSpace(string(typ)), C: f})
+			out = append(out, Temp{Sensor: sensor, Label: sensor, C: f})
 		}
-		return nil
-	})
+	}
+	out = append(out, readHwmonTemps()...)
+	for i := range out {
+		out[i].FriendlyName = tempFriendlyName(out[i])
+	}
 	return out
 }
 
-func ensureDir(p string) {
-	if err := os.MkdirAll(p, 0o755); err != nil {
-		log.Fatalf("mkdir %s: %v", p, err)
+// readHwmonTemps reads per-core CPU temperatures from hwmon, where each
+// temp*_input has a matching temp*_label such as "Core 0" or "Package id 0".
+func readHwmonTemps() []Temp {
+	var out []Temp
+	chips, _ := filepath.Glob(sysPath("class/hwmon/hwmon*"))
+	for _, chip := range chips {
+		name, err := readFile(filepath.Join(chip, "name"))
+		if err != nil || !cpuHwmonNames[name] || !tempAllowed(name) {
+			continue
+		}
+		inputs, _ := filepath.Glob(filepath.Join(chip, "temp*_input"))
+		sort.Slice(inputs, func(i, j int) bool { return hwmonIndex(inputs[i]) < hwmonIndex(inputs[j]) })
+		for _, in := range inputs {
+			raw, err := readFile(in)
+			if err != nil {
+				continue
+			}
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			prefix := strings.TrimSuffix(filepath.Base(in), "_input")
+			label, err := readFile(filepath.Join(chip, prefix+"_label"))
+			if err != nil {
+				label = prefix
+			}
+			// hwmon temp*_input is always millidegrees Celsius
+			out = append(out, Temp{Sensor: name, Label: label, C: v / 1000})
+		}
 	}
+	return out
 }
 
-func writeJSON(m Metrics) {
-	ensureDir(outDir)
-	path := filepath.Join(outDir, outFile)
-	tmp := path + ".tmp"
-	b, _ := json.MarshalIndent(m, "", "  ")
-	if err := os.WriteFile(tmp, b, 0o644); err == nil {
-		_ = os.Rename(tmp, path)
+// hwmonIndex extracts N from ".../tempN_input" so temp10 sorts after temp9.
+func hwmonIndex(path string) int {
+	base := strings.TrimPrefix(filepath.Base(path), "temp")
+	n, _ := strconv.Atoi(strings.TrimSuffix(base, "_input"))
+	return n
+}
+
+// unescapeMount decodes the octal escapes (\040 for space etc.) used in /proc/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
 	}
+	return b.String()
 }
 
-func collectLoop() {
-	host, _ := os.Hostname()
-	kernel := readKernel()
-	cores := runtime.NumCPU()
-	prev, _ := parseCPUTimes()
-	for {
-		start := time.Now()
-		cur, errCT := parseCPUTimes()
-		memT, memA, swT, swF, errM := readMem()
-		l1, l5, l15, errL := readLoad()
-		up, errU := readUptime()
-		net := readNet()
-		temps := readTemps()
-
-		errs := []string{}
-		if errCT != nil {
-			errs = append(errs, "cpustat:"+errCT.Error())
-		}
-		if errM != nil {
-			errs = append(errs, "meminfo:"+errM.Error())
-		}
-		if errL != nil {
-			errs = append(errs, "loadavg:"+errL.Error())
-		}
-		if errU != nil {
-			errs = append(errs, "uptime:"+errU.Error())
-		}
-
-		cpuPct := cpuPercent(prev, cur)
-		prev = cur
-
-		m := Metrics{
-			Timestamp: time.Now(),
-			Hostname:  host,
-			OS:        runtime.GOOS + "/" + runtime.GOARCH,
-			Kernel:    kernel,
-			UptimeSec: up,
-			Load1:     l1, Load5: l5, Load15: l15,
-			CPUPercent: cpuPct,
-			CPUCores:   cores,
-			MemTotalB:  memT, MemAvailB: memA,
-			SwapTotalB: swT, SwapFreeB: swF,
-			Net:   net,
-			Temps: temps,
-		}
-		if len(errs) > 0 {
-			m.LastError = strings.Join(errs, "; ")
-		}
-
-		mtx.Lock()
-		current = m
-		history = append(history, m)
-		if len(history) > 120 {
-			history = history[1:]
+type mountEntry struct {
+	dev, mnt, typ string
+	ro            bool
+}
+
+// readMounts parses /proc/mounts: device mountpoint fstype options dump pass.
+func readMounts() ([]mountEntry, error) {
+	b, err := readRetry(procPath("mounts"))
+	if err != nil {
+		return nil, err
+	}
+	var out []mountEntry
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		me := mountEntry{dev: fields[0], mnt: unescapeMount(fields[1]), typ: fields[2]}
+		if len(fields) > 3 {
+			me.ro = slices.Contains(strings.Split(fields[3], ","), "ro")
+		}
+		out = append(out, me)
+	}
+	return out, sc.Err()
+}
+
+// readDisks reports the mounts pinned by SYSDASH_DISK_MOUNTS, or else every
+// mounted block-device filesystem.
+func readDisks() ([]DiskStat, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []mountEntry
+	if len(diskMounts) > 0 {
+		// later entries win, so an over-mounted path reports what's on top
+		byMnt := map[string]mountEntry{}
+		for _, me := range mounts {
+			byMnt[me.mnt] = me
+		}
+		for _, mnt := range diskMounts {
+			me, ok := byMnt[mnt]
+			if !ok {
+				me = mountEntry{mnt: mnt}
+			}
+			targets = append(targets, me)
+		}
+	} else {
+		seen := map[string]bool{}
+		for _, me := range mounts {
+			// only real block devices (plus zfs datasets); skip pseudo fs and snap loops
+			if !strings.HasPrefix(me.dev, "/dev/") && me.typ != "zfs" {
+				continue
+			}
+			if me.typ == "squashfs" || seen[me.mnt] {
+				continue
+			}
+			seen[me.mnt] = true
+			targets = append(targets, me)
 		}
-		mtx.Unlock()
-		writeJSON(m)
+	}
 
-		time.Sleep(time.Until(start.Add(sampleEvery)))
+	var out []DiskStat
+	var failed []string
+	for _, me := range targets {
+		d, err := statDisk(me)
+		if err != nil {
+			// auto-detected mounts can vanish between reads; only pinned ones are errors
+			if len(diskMounts) > 0 {
+				failed = append(failed, me.mnt+": "+err.Error())
+			}
+			continue
+		}
+		out = append(out, d)
+	}
+	if len(failed) > 0 {
+		return out, errors.New(strings.Join(failed, ", "))
 	}
+	return out, nil
 }
 
-func main() {
-	if v := os.Getenv("SYSDASH_OUTDIR"); v != "" {
-		outDir = v
+// stRdonly is ST_RDONLY in statfs f_flags; package syscall doesn't export it.
+const stRdonly = 0x1
+
+func statDisk(me mountEntry) (DiskStat, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(me.mnt, &st); err != nil {
+		return DiskStat{}, err
 	}
-	if v := os.Getenv("SYSDASH_INTERVAL"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			sampleEvery = d
+	bs := uint64(st.Bsize)
+	d := DiskStat{
+		Mount:       me.mnt,
+		Device:      me.dev,
+		FSType:      me.typ,
+		TotalB:      st.Blocks * bs,
+		FreeB:       st.Bfree * bs,
+		AvailB:      st.Bavail * bs,
+		InodesTotal: st.Files,
+		InodesFree:  st.Ffree,
+		// ST_RDONLY also covers mounts pinned via SYSDASH_DISK_MOUNTS that
+		// weren't found in /proc/mounts
+		ReadOnly: me.ro || st.Flags&stRdonly != 0,
+	}
+	// same as df: used / (used + available to unprivileged users)
+	used := d.TotalB - d.FreeB
+	if used+d.AvailB > 0 {
+		d.UsedPct = float64(used) / float64(used+d.AvailB) * 100
+	}
+	// some filesystems (btrfs, vfat) report zero inodes; leave pct at 0
+	if d.InodesTotal > 0 {
+		d.InodesUsedPct = float64(d.InodesTotal-d.InodesFree) / float64(d.InodesTotal) * 100
+	}
+	return d, nil
+}
+
+func memUsedPct(m Metrics) float64 {
+	if m.MemTotalB == 0 {
+		return 0
+	}
+	return float64(m.MemTotalB-m.MemAvailB) / float64(m.MemTotalB) * 100
+}
+
+// cachedHistoryJSON marshals history at most once per sample; collectLoop
+// clears historyJSON whenever a new sample arrives.
+func cachedHistoryJSON() []byte {
+	mtx.RLock()
+	b := historyJSON
+	mtx.RUnlock()
+	if b != nil {
+		return b
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if historyJSON == nil {
+		h := history
+		if h == nil {
+			h = []Metrics{} // "[]" rather than "null" before the first sample
 		}
+		historyJSON, _ = marshalJSON(h)
 	}
+	return historyJSON
+}
 
-	subFS, err := fs.Sub(webFS, "web")
-	if err != nil {
-		log.Fatalf("failed to prepare embedded FS: %v", err)
+// etagMatch reports whether an If-None-Match header matches etag, including
+// lists and weak validators.
+func etagMatch(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
 	}
+	return false
+}
 
-	// New: support port flag/env
-	port := flag.String("port", "", "Port to listen on (default 8080 or from SYSDASH_PORT)")
-	flag.Parse()
+func snapshotHistory() []Metrics {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	h := make([]Metrics, len(history))
+	copy(h, history)
+	return h
+}
 
-	addr := ":8081" // default
-	if envPort := os.Getenv("SYSDASH_PORT"); envPort != "" {
-		addr = fmt.Sprintf(":%s", envPort)
+// historyAt returns the newest history sample taken at or before t, or false
+// when the history starts after t.
+func historyAt(t time.Time) (Metrics, bool) {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	i := sort.Search(len(history), func(i int) bool { return history[i].Timestamp.After(t) })
+	if i == 0 {
+		return Metrics{}, false
 	}
-	if *port != "" {
-		addr = fmt.Sprintf(":%s", *port)
+	return history[i-1], true
+}
+
+// limitHistory keeps the samples of h newer than since (when set) and, of
+// those, at most limit of the most recent ones. It reports whether the limit
+// cut any.
+func limitHistory(h []Metrics, since time.Time, limit int) ([]Metrics, bool) {
+	if !since.IsZero() {
+		i := sort.Search(len(h), func(i int) bool { return h[i].Timestamp.After(since) })
+		h = h[i:]
+	}
+	if limit > 0 && len(h) > limit {
+		return h[len(h)-limit:], true
 	}
+	return h, false
+}
 
-	go collectLoop()
+func downsampleHistory(res time.Duration) []Metrics {
+	mtx.RLock()
+	h := downsample(history, res)
+	mtx.RUnlock()
+	roundFloats(&h)
+	return h
+}
 
-	mux := http.NewServeMux()
-	mux.Handle("/", http.FileServer(http.FS(subFS)))
-	mux.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
-		mtx.RLock()
-		m := current
-		mtx.RUnlock()
-		b, _ := json.MarshalIndent(m, "", "  ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(b)
-	})
-	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
-		mtx.RLock()
-		h := make([]Metrics, len(history))
-		copy(h, history)
-		mtx.RUnlock()
-		b, _ := json.MarshalIndent(h, "", "  ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(b)
-	})
-	mux.HandleFunc("/api/metrics.json", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(outDir, outFile))
-	})
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		_, _ = w.Write([]byte("ok"))
+// downsample buckets h into res-wide windows and returns one synthetic sample
+// per bucket: CPU, load and memory are averaged, everything else is taken
+// from the last sample in the bucket.
+func downsample(h []Metrics, res time.Duration) []Metrics {
+	out := []Metrics{}
+	var acc Metrics
+	var bucket time.Time
+	n := 0
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		f := float64(n)
+		acc.Timestamp = bucket
+		acc.CPUPercent /= f
+		acc.Load1 /= f
+		acc.Load5 /= f
+		acc.Load15 /= f
+		acc.MemTotalB /= uint64(n)
+		acc.MemAvailB /= uint64(n)
+		acc.SwapTotalB /= uint64(n)
+		acc.SwapFreeB /= uint64(n)
+		out = append(out, acc)
+	}
+	for _, m := range h {
+		b := m.Timestamp.Truncate(res)
+		if n == 0 || !b.Equal(bucket) {
+			flush()
+			bucket, n = b, 0
+			acc = Metrics{}
+		}
+		sums := acc
+		acc = m
+		acc.CPUPercent += sums.CPUPercent
+		acc.Load1 += sums.Load1
+		acc.Load5 += sums.Load5
+		acc.Load15 += sums.Load15
+		acc.MemTotalB += sums.MemTotalB
+		acc.MemAvailB += sums.MemAvailB
+		acc.SwapTotalB += sums.SwapTotalB
+		acc.SwapFreeB += sums.SwapFreeB
+		n++
+	}
+	flush()
+	return out
+}
+
+func writeHistoryCSV(w http.ResponseWriter, h []Metrics) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="history.csv"`)
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{
+		"timestamp", "cpu_percent", "load1", "load5", "load15",
+		"mem_used_pct", "mem_total_bytes", "mem_available_bytes",
+		"swap_total_bytes", "swap_free_bytes", "uptime_sec",
 	})
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	fu := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	for _, m := range h {
+		_ = cw.Write([]string{
+			m.Timestamp.Format(time.RFC3339), ff(m.CPUPercent), ff(m.Load1), ff(m.Load5), ff(m.Load15),
+			ff(memUsedPct(m)), fu(m.MemTotalB), fu(m.MemAvailB),
+			fu(m.SwapTotalB), fu(m.SwapFreeB), fu(m.UptimeSec),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logErr(err, "[history.csv] write error")
+	}
+}
+
+// writeMetricsText renders one "key value" line per metric for grep/awk.
+// Per-interface and per-mount values use "key.name" so names stay greppable.
+func writeMetricsText(w http.ResponseWriter, m Metrics) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var b strings.Builder
+	ff := func(k string, v float64) { fmt.Fprintf(&b, "%s %s\n", k, strconv.FormatFloat(v, 'f', 2, 64)) }
+	fu := func(k string, v uint64) { fmt.Fprintf(&b, "%s %d\n", k, v) }
+	fmt.Fprintf(&b, "timestamp %d\n", m.Timestamp.Unix())
+	fu("uptime_sec", m.UptimeSec)
+	ff("cpu_percent", m.CPUPercent)
+	ff("cpu_iowait_percent", m.CPUIOWaitPercent)
+	ff("cpu_steal_percent", m.CPUStealPercent)
+	fu("cpu_cores", uint64(m.CPUCores))
+	ff("load1", m.Load1)
+	ff("load5", m.Load5)
+	ff("load15", m.Load15)
+	ff("mem_used_pct", memUsedPct(m))
+	fu("mem_total_bytes", m.MemTotalB)
+	fu("mem_available_bytes", m.MemAvailB)
+	fu("swap_total_bytes", m.SwapTotalB)
+	fu("swap_free_bytes", m.SwapFreeB)
+	ff("context_switches_per_sec", m.ContextSwitchesPerSec)
+	ff("interrupts_per_sec", m.InterruptsPerSec)
+	ff("forks_per_sec", m.ForksPerSec)
+	fu("procs_running", uint64(m.ProcsRunning))
+	fu("procs_blocked", uint64(m.ProcsBlocked))
+	ff("health_score", m.HealthScore)
+	fu("process_count", uint64(m.ProcessCount))
+	fu("thread_count", uint64(m.ThreadCount))
+	fu("zombie_count", uint64(m.ZombieCount))
+	fu("fd_allocated", m.FDAllocated)
+	fu("fd_max", m.FDMax)
+	if uplinkIface != "" {
+		ff("uplink_rx_bps", m.UplinkRxBps)
+		ff("uplink_tx_bps", m.UplinkTxBps)
+	}
+	for _, n := range m.Net {
+		ff("net_rx_bps."+n.Name, n.RxBps)
+		ff("net_tx_bps."+n.Name, n.TxBps)
+	}
+	for _, d := range m.Disks {
+		ff("disk_used_pct."+d.Mount, d.UsedPct)
+	}
+	for _, t := range m.Temps {
+		name := t.Label
+		if name == "" {
+			name = t.Sensor
+		}
+		ff("temp_c."+strings.ReplaceAll(name, " ", "_"), t.C)
+	}
+	io.WriteString(w, b.String())
+}
+
+// metricsFieldNames is the set of top-level JSON keys a Metrics can have.
+var metricsFieldNames = sync.OnceValue(func() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(Metrics{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+})
 
-	log.Printf("sysdashd listening on %s, writing %s/%s (interval %s)", addr, outDir, outFile, sampleEvery)
-	log.Fatal(http.ListenAndServe(addr, mux))
+// parseFields splits a ?fields= list and rejects names Metrics doesn't have,
+// so a typo is an error rather than a page of empty objects.
+func parseFields(v string) ([]string, error) {
+	var out []string
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if !metricsFieldNames()[f] {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		out = append(out, f)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("fields: empty list")
+	}
+	return out, nil
+}
+
+// projectFields cuts a JSON array of samples down to the given keys. It works
+// on the already-marshalled history so the cached bytes can be reused.
+func projectFields(b []byte, fields []string) ([]byte, error) {
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &rows); err != nil {
+		return nil, err
+	}
+	out := make([]map[string]json.RawMessage, len(rows))
+	for i, row := range rows {
+		p := make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := row[f]; ok {
+				p[f] = v
+			}
+		}
+		out[i] = p
+	}
+	return marshalJSON(out)
+}
+
+func statOf(h []Metrics, get func(Metrics) float64) Stat {
+	if len(h) == 0 {
+		return Stat{}
+	}
+	st := Stat{Min: math.Inf(1), Max: math.Inf(-1)}
+	sum := 0.0
+	for _, m := range h {
+		v := get(m)
+		st.Min = math.Min(st.Min, v)
+		st.Max = math.Max(st.Max, v)
+		sum += v
+	}
+	st.Avg = sum / float64(len(h))
+	return st
+}
+
+func summarize() Summary {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	s := Summary{Samples: len(history)}
+	if len(history) == 0 {
+		return s
+	}
+	s.From = history[0].Timestamp
+	s.To = history[len(history)-1].Timestamp
+	s.WindowSec = s.To.Sub(s.From).Seconds()
+	s.CPUPercent = statOf(history, func(m Metrics) float64 { return m.CPUPercent })
+	s.Load1 = statOf(history, func(m Metrics) float64 { return m.Load1 })
+	s.MemUsedPct = statOf(history, memUsedPct)
+	return s
+}
+
+// validateOutput rejects output settings that could write outside outDir.
+func validateOutput(dir, file string) error {
+	if file == "" || file == "." || file == ".." || strings.ContainsRune(file, '/') || strings.ContainsRune(file, filepath.Separator) {
+		return fmt.Errorf("output file %q must be a plain file name without path separators", file)
+	}
+	if dir == "" {
+		return errors.New("output directory is empty")
+	}
+	for _, part := range strings.Split(filepath.ToSlash(dir), "/") {
+		if part == ".." {
+			return fmt.Errorf("output directory %q must not contain '..'", dir)
+		}
+	}
+	return nil
+}
+
+// expandPath resolves a leading ~ to the home directory and $VAR or ${VAR}
+// anywhere, for values that no shell expanded (systemd Environment=, docker
+// env files). Unset variables are an error rather than silently empty, which
+// would turn $STATE/sysdash into /sysdash.
+func expandPath(p string) (string, error) {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("expanding ~ in %q: %v", p, err)
+		}
+		p = home + p[1:]
+	}
+	var missing []string
+	p = os.Expand(p, func(k string) string {
+		v, ok := os.LookupEnv(k)
+		if !ok {
+			missing = append(missing, k)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unset variable(s) in path: %s", strings.Join(missing, ", "))
+	}
+	return p, nil
+}
+
+func ensureDir(p string) {
+	if err := os.MkdirAll(p, 0o755); err != nil {
+		fatalErr(err, "mkdir %s", p)
+	}
+}
+
+// writeTemp writes b to tmp, fsyncing before close when durableWrite is set so
+// the data is on disk before the rename makes it visible.
+func writeTemp(tmp string, b []byte) error {
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if durableWrite {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// syncDir persists the rename itself; without it a crash can roll the
+// directory entry back to the old file on some filesystems.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// marshalJSON is used for everything we serve or write: two-space indented by
+// default, compact with SYSDASH_COMPACT_JSON=1.
+func marshalJSON(v any) ([]byte, error) {
+	if compactJSON {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func writeJSON(m Metrics) error {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(outDir, outFile)
+	tmp := path + ".tmp"
+	b, _ := marshalJSON(m)
+	if err := writeTemp(tmp, b); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if durableWrite {
+		return syncDir(outDir)
+	}
+	return nil
+}
+
+// recordWrite tracks consecutive writeJSON failures. The error is logged when
+// the streak starts and ends rather than every interval, and is reported in
+// the next sample's last_error/write_failures so it shows up in the UI.
+func recordWrite(err error) {
+	writeMtx.Lock()
+	defer writeMtx.Unlock()
+	if err == nil {
+		if writeFailures > 0 {
+			log.Printf("[writeJSON] recovered after %d failed writes", writeFailures)
+		}
+		writeFailures, writeErr = 0, nil
+		return
+	}
+	if writeFailures == 0 {
+		logErr(err, "[writeJSON] write failed")
+	}
+	writeFailures++
+	writeErr = err
+}
+
+func lastWriteError() (uint64, error) {
+	writeMtx.Lock()
+	defer writeMtx.Unlock()
+	return writeFailures, writeErr
+}
+
+// File output runs on writeLoop so a slow disk (a NAS on spinning disks, an
+// SD card doing garbage collection) can't hold up sampling. Like pushCh the
+// queue holds one sample; if the writer is behind, only the newest is kept.
+var writeCh = make(chan Metrics, 1)
+
+func enqueueWrite(m Metrics) {
+	if noFile && promTextfile == "" {
+		return
+	}
+	for {
+		select {
+		case writeCh <- m:
+			return
+		default:
+		}
+		select {
+		case <-writeCh:
+		default:
+		}
+	}
+}
+
+func writeLoop() {
+	for {
+		select {
+		case m := <-writeCh:
+			if !noFile {
+				recordWrite(writeJSON(m))
+			}
+			if promTextfile != "" {
+				writePromTextfile(m)
+			}
+		case m := <-persistCh:
+			if err := histStore.append(m); err != nil {
+				logErr(err, "[persist] write failed")
+			}
+		}
+	}
+}
+
+var (
+	// collectors (SYSDASH_COLLECTORS) limits which readers sample runs; nil
+	// runs all of them
+	collectors     map[string]bool
+	collectorNames = []string{
+		"cpu", "mem", "load", "uptime", "net", "conns", "temps", "disks",
+		"diskio", "disktemps", "raid", "psi", "kernel", "gpu", "procs",
+	}
+	errCollectorOff = errors.New("collector disabled")
+)
+
+func collectorOn(name string) bool {
+	return collectors == nil || collectors[name]
+}
+
+// collectIf is collect for a reader that SYSDASH_COLLECTORS can switch off.
+// A disabled reader returns the zero value and errCollectorOff, which keeps
+// the error-guarded bookkeeping after it from running. key names the call
+// for collect's single-flight check; a collector with several readers tells
+// them apart after a slash ("cpu/cores").
+func collectIf[T any](key string, fn func() (T, error)) (T, error) {
+	name, _, _ := strings.Cut(key, "/")
+	if !collectorOn(name) {
+		var zero T
+		return zero, errCollectorOff
+	}
+	return collect(key, fn)
+}
+
+var (
+	// keys of the collect calls whose reader hasn't returned yet
+	inflightMtx sync.Mutex
+	inflight    = map[string]bool{}
+
+	errStillRunning = errors.New("timeout: previous call still running")
+)
+
+// collect runs a single reader with a deadline, converting a panic into an
+// error so one bad reader degrades the sample instead of killing collectLoop.
+// A reader that blocks past collectTimeout (e.g. Statfs on a stuck NFS mount)
+// is abandoned: its goroutine finishes in the background and the result is
+// dropped. Until it does, calls with the same key fail at once with
+// errStillRunning instead of stacking up another blocked goroutine (and OS
+// thread) every interval.
+func collect[T any](key string, fn func() (T, error)) (T, error) {
+	inflightMtx.Lock()
+	if inflight[key] {
+		inflightMtx.Unlock()
+		var zero T
+		return zero, errStillRunning
+	}
+	inflight[key] = true
+	inflightMtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var res result
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[collect] recovered panic: %v", r)
+				res.err = fmt.Errorf("panic: %v", r)
+			}
+			inflightMtx.Lock()
+			delete(inflight, key)
+			inflightMtx.Unlock()
+			ch <- res
+		}()
+		res.v, res.err = fn()
+	}()
+
+	select {
+	case res := <-ch:
+		return res.v, res.err
+	case <-ctx.Done():
+		var zero T
+		return zero, errors.New("timeout")
+	}
+}
+
+// trimHistory drops samples beyond the retention limit; callers hold mtx.
+func trimHistory(now time.Time) {
+	if historyDuration > 0 {
+		cutoff := now.Add(-historyDuration)
+		i := 0
+		for i < len(history) && history[i].Timestamp.Before(cutoff) {
+			i++
+		}
+		history = history[i:]
+		return
+	}
+	if len(history) > historyLen {
+		history = history[len(history)-historyLen:]
+	}
+}
+
+// sampler carries the state needed between samples, like the previous CPU
+// counters that CPU percent is computed against.
+type sampler struct {
+	host, kernel string
+	cores        int
+	prev         cpuStat
+	prevAt       time.Time
+	prevProcs    procTicks
+	prevProcAt   time.Time
+	prevNet      map[string]NetStat
+	prevNetAt    time.Time
+	flaps        *flapTracker
+	prevDiskIO   map[string]DiskIOStat
+	prevDiskIOAt time.Time
+	cpuEMA       float64
+	cpuEMAInit   bool
+}
+
+func newSampler() *sampler {
+	host, _ := os.Hostname()
+	prev, _ := readCPUStat()
+	// baseline per-process ticks so the first sample has CPU percentages
+	var procs procWalk
+	if collectorOn("procs") {
+		procs, _ = readProcs(nil, 0)
+	}
+	s := &sampler{
+		host: host, kernel: readKernel(), cores: runtime.NumCPU(),
+		prev: prev, prevAt: time.Now(), prevProcs: procs.ticks, prevProcAt: time.Now(),
+		prevNet: map[string]NetStat{}, prevNetAt: time.Now(),
+		flaps:      newFlapTracker(),
+		prevDiskIO: map[string]DiskIOStat{}, prevDiskIOAt: time.Now(),
+	}
+	if collectorOn("net") {
+		for _, n := range readNet() {
+			s.prevNet[n.Name] = n
+		}
+	}
+	if collectorOn("diskio") {
+		dio, _ := readDiskIO()
+		for _, d := range dio {
+			s.prevDiskIO[d.Device] = d
+		}
+	}
+	return s
+}
+
+// sample runs every reader once and assembles the result into a Metrics.
+func (s *sampler) sample() Metrics {
+	start := time.Now()
+	cpuAt := time.Now()
+	cs, errCT := collectIf("cpu", readCPUStat)
+	cinfo, errCI := collectIf("cpu/cores", func() (map[int]coreInfo, error) { return readCoreInfo(cs.perCPU) })
+	type memRead struct {
+		total, avail, swapT, swapF uint64
+		detail                     MemDetail
+		cgroup                     bool
+		cgroupErr                  error
+	}
+	mem, errM := collectIf("mem", func() (memRead, error) {
+		var r memRead
+		var err error
+		r.total, r.avail, r.swapT, r.swapF, r.detail, err = readMem()
+		if cgroupMem && r.total > 0 {
+			limit, used, ok, cerr := readCgroupMem()
+			r.cgroupErr = cerr
+			if ok && limit < r.total {
+				r.total, r.avail, r.cgroup = limit, limit-min(used, limit), true
+			}
+		}
+		return r, err
+	})
+	load, errL := collectIf("load", func() ([3]float64, error) {
+		l1, l5, l15, err := readLoad()
+		return [3]float64{l1, l5, l15}, err
+	})
+	up, errU := collectIf("uptime", readUptime)
+	netAt := time.Now()
+	net, errN := collectIf("net", func() ([]NetStat, error) { return readNet(), nil })
+	if errN == nil {
+		applyNetRates(net, s.prevNet, netAt.Sub(s.prevNetAt).Seconds())
+		s.flaps.update(net, s.prevNet, netAt)
+		s.prevNet, s.prevNetAt = make(map[string]NetStat, len(net)), netAt
+		for _, n := range net {
+			s.prevNet[n.Name] = n
+		}
+	}
+	conns, errC := collectIf("conns", func() (map[string]int, error) { return readConns("") })
+	conns6, errC6 := collectIf("conns/6", func() (map[string]int, error) { return readConns("6") })
+	temps, errT := collectIf("temps", func() ([]Temp, error) { return readTemps(), nil })
+	disks, errD := collectIf("disks", readDisks)
+	dioAt := time.Now()
+	diskIO, errDIO := collectIf("diskio", readDiskIO)
+	if errDIO == nil {
+		applyDiskIORates(diskIO, s.prevDiskIO, dioAt.Sub(s.prevDiskIOAt).Seconds())
+		s.prevDiskIO, s.prevDiskIOAt = make(map[string]DiskIOStat, len(diskIO)), dioAt
+		for _, d := range diskIO {
+			s.prevDiskIO[d.Device] = d
+		}
+	}
+	diskTemps, errDT := collectIf("disktemps", readDiskTemps)
+	raid, errR := collectIf("raid", readRAID)
+	psi, errP := collectIf("psi", readPSI)
+	km, errK := collectIf("kernel", readKernelMisc)
+	gpus, errG := collectIf("gpu", readGPU)
+	procStart := time.Now()
+	procs, errPr := collectIf("procs", func() (procWalk, error) {
+		return readProcs(s.prevProcs, procStart.Sub(s.prevProcAt).Seconds())
+	})
+	if errPr == nil {
+		s.prevProcs, s.prevProcAt = procs.ticks, procStart
+		setLastProcs(procs.all)
+	}
+
+	errs := []string{}
+	addErr := func(name string, err error) {
+		if err != nil && err != errCollectorOff {
+			errs = append(errs, name+":"+err.Error())
+		}
+	}
+	addErr("cpustat", errCT)
+	addErr("cores", errCI)
+	addErr("meminfo", errM)
+	addErr("cgroup", mem.cgroupErr)
+	addErr("loadavg", errL)
+	addErr("uptime", errU)
+	addErr("net", errN)
+	addErr("conns", errC)
+	addErr("conns6", errC6)
+	addErr("temps", errT)
+	addErr("disks", errD)
+	addErr("diskio", errDIO)
+	addErr("disktemps", errDT)
+	addErr("mdstat", errR)
+	addErr("psi", errP)
+	addErr("kernel", errK)
+	addErr("gpu", errG)
+	addErr("procs", errPr)
+	wf, errW := lastWriteError()
+	addErr("write", errW)
+
+	var cpu CPUBreakdown
+	var cores []CoreStat
+	var ctxtRate, intrRate, forkRate float64
+	if errCT == nil {
+		cpu = cpuBreakdown(s.prev.total, cs.total)
+		elapsed := cpuAt.Sub(s.prevAt).Seconds()
+		ctxtRate = counterRate(s.prev.ctxt, cs.ctxt, elapsed)
+		intrRate = counterRate(s.prev.intr, cs.intr, elapsed)
+		forkRate = counterRate(s.prev.forks, cs.forks, elapsed)
+		if cpuEMAAlpha > 0 {
+			if !s.cpuEMAInit {
+				s.cpuEMA, s.cpuEMAInit = cpu.Busy, true
+			} else {
+				s.cpuEMA += cpuEMAAlpha * (cpu.Busy - s.cpuEMA)
+			}
+		}
+		cores = readCores(s.prev.perCPU, cs.perCPU, cinfo, temps)
+		s.prev, s.prevAt = cs, cpuAt
+	}
+
+	m := Metrics{
+		Timestamp: time.Now().In(tz),
+		Hostname:  s.host,
+		OS:        runtime.GOOS + "/" + runtime.GOARCH,
+		Kernel:    s.kernel,
+		UptimeSec: up,
+		Load1:     load[0], Load5: load[1], Load15: load[2],
+		LoadSource:            loadSource,
+		CPUPercent:            cpu.Busy,
+		CPUPercentSmoothed:    s.cpuEMA,
+		CPUIOWaitPercent:      cpu.IOWait,
+		CPUStealPercent:       cpu.Steal,
+		CPUCores:              s.cores,
+		ContextSwitchesPerSec: ctxtRate,
+		InterruptsPerSec:      intrRate,
+		ForksPerSec:           forkRate,
+		ProcsRunning:          cs.running,
+		ProcsBlocked:          cs.blocked,
+		Cores:                 cores,
+		MemTotalB:             mem.total,
+		MemAvailB:             mem.avail,
+		MemAvailEstimated:     errors.Is(errM, errMemEstimated),
+		MemCgroup:             mem.cgroup,
+		MemDetail:             mem.detail,
+		SwapTotalB:            mem.swapT,
+		SwapFreeB:             mem.swapF,
+		Net:                   net,
+		Connections:           conns,
+		ConnectionsV6:         conns6,
+		Temps:                 temps,
+		Disks:                 disks,
+		DiskIO:                diskIO,
+		DiskTemps:             diskTemps,
+		RAIDArrays:            raid,
+		PSI:                   psi,
+		EntropyAvail:          km.entropy,
+		FDAllocated:           km.fdAlloc,
+		FDMax:                 km.fdMax,
+		GPUs:                  gpus,
+		Processes:             procs.top,
+		WatchedProcesses:      procs.watched,
+		ProcessCount:          procs.procs,
+		ThreadCount:           procs.threads,
+		ZombieCount:           procs.zombies,
+	}
+	if loadSource == "psi" {
+		// a container sees the host's run queue in loadavg; CPU pressure
+		// ("some" = % of time at least one task waited for a CPU) is its own
+		if psi != nil {
+			m.Load1, m.Load5, m.Load15 = psi.CPU.Some.Avg10, psi.CPU.Some.Avg60, psi.CPU.Some.Avg300
+		} else {
+			m.LoadSource = "loadavg"
+			if errP == nil {
+				errs = append(errs, "load:no /proc/pressure/cpu, using loadavg")
+			}
+		}
+	}
+	if uplinkIface != "" && errN == nil {
+		found := false
+		for _, n := range net {
+			if n.Name == uplinkIface {
+				m.UplinkRxBps, m.UplinkTxBps, found = n.RxBps, n.TxBps, true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, "uplink:no interface "+uplinkIface)
+		}
+	}
+	if len(errs) > 0 {
+		m.LastError = strings.Join(errs, "; ")
+	}
+	m.PushFailures = pushFailures.Load()
+	m.WriteFailures = wf
+	m.CollectionDurationMs = float64(time.Since(start).Microseconds()) / 1000
+	m.Self = readSelf(m.CollectionDurationMs)
+	return m
+}
+
+func readSelf(collectionMs float64) SelfStat {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return SelfStat{
+		Goroutines:      runtime.NumGoroutine(),
+		HeapAllocB:      ms.HeapAlloc,
+		SysB:            ms.Sys,
+		CollectionMs:    collectionMs,
+		CollectRestarts: collectRestarts.Load(),
+	}
+}
+
+// appendError adds msg to the sample's semicolon-separated LastError.
+func (m *Metrics) appendError(msg string) {
+	if m.LastError != "" {
+		m.LastError += "; "
+	}
+	m.LastError += msg
+}
+
+// ErrorEvent is one distinct collector error. Repeats of a message already in
+// the list bump Count and Time instead of pushing older, rarer errors out.
+type ErrorEvent struct {
+	Time  time.Time `json:"time"`
+	Msg   string    `json:"msg"`
+	Count int       `json:"count"`
+}
+
+const maxRecentErrors = 20
+
+var (
+	errMtx       sync.Mutex
+	recentErrors []ErrorEvent
+)
+
+// recordErrors splits a sample's LastError into recentErrors, newest last.
+func recordErrors(at time.Time, lastError string) {
+	if lastError == "" {
+		return
+	}
+	errMtx.Lock()
+	defer errMtx.Unlock()
+	for _, msg := range strings.Split(lastError, "; ") {
+		ev := ErrorEvent{Time: at, Msg: msg, Count: 1}
+		for i, e := range recentErrors {
+			if e.Msg == msg {
+				ev.Count = e.Count + 1
+				recentErrors = append(recentErrors[:i], recentErrors[i+1:]...)
+				break
+			}
+		}
+		recentErrors = append(recentErrors, ev)
+		if len(recentErrors) > maxRecentErrors {
+			recentErrors = recentErrors[len(recentErrors)-maxRecentErrors:]
+		}
+	}
+}
+
+func handleErrors(w http.ResponseWriter, r *http.Request) {
+	errMtx.Lock()
+	out := append([]ErrorEvent{}, recentErrors...)
+	errMtx.Unlock()
+	b, _ := marshalJSON(out)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+var (
+	collectRestarts atomic.Uint64
+	// first wait before restarting a crashed collectLoop; doubles up to
+	// maxCollectBackoff while it keeps crashing
+	collectBackoff    = time.Second
+	maxCollectBackoff = 30 * time.Second
+)
+
+// superviseCollect runs loop (collectLoop) and restarts it whenever it
+// panics or returns, so a bug that kills the loop leaves a gap in the charts
+// rather than a dashboard frozen on its last sample.
+func superviseCollect(loop func()) {
+	backoff := collectBackoff
+	for {
+		start := time.Now()
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[collectLoop] crashed: %v\n%s", r, debug.Stack())
+				}
+			}()
+			loop()
+		}()
+		if time.Since(start) > maxCollectBackoff {
+			backoff = collectBackoff
+		}
+		n := collectRestarts.Add(1)
+		log.Printf("[collectLoop] restarting in %s (restart %d)", backoff, n)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxCollectBackoff)
+	}
+}
+
+// publish makes m the current sample and hands it to history, live
+// subscribers, the file writer, the history file and push. Samples taken
+// before warmupUntil are served but leave /readyz unready and, with
+// SYSDASH_WARMUP_DISCARD, stay out of the history.
+func publish(m Metrics) {
+	roundFloats(&m)
+	m.WarmingUp = m.Timestamp.Before(warmupUntil)
+	keep := !m.WarmingUp || !warmupDiscard
+
+	b, _ := marshalJSON(m)
+	mtx.Lock()
+	current = m
+	currentJSON = b
+	currentETag = `"` + strconv.FormatInt(m.Timestamp.UnixNano(), 36) + `"`
+	if keep {
+		history = append(history, m)
+		trimHistory(m.Timestamp)
+		historyJSON = nil
+	}
+	mtx.Unlock()
+	if !m.WarmingUp {
+		ready.Store(true)
+	}
+	broadcast(b)
+	enqueueWrite(m)
+	if histStore != nil && keep {
+		enqueuePersist(m)
+	}
+	enqueuePush(m)
+}
+
+func collectLoop() {
+	s := newSampler()
+	alerts := newAlerter()
+	time.Sleep(cpuWarmup)
+	// samples are scheduled on a fixed grid (next) and only the individual
+	// sleeps are jittered, so the offsets never add up to drift
+	next := time.Now()
+	for {
+		start := time.Now()
+		m := s.sample()
+		// a collection slower than the interval means the next sample starts
+		// immediately; say so instead of silently producing back-to-back samples
+		behind := time.Since(start) > sampleEvery
+		if behind {
+			m.appendError(fmt.Sprintf("collect:took %.0fms, longer than interval %s", m.CollectionDurationMs, sampleEvery))
+			log.Printf("[collectLoop] sampling is falling behind: collection took %.0fms (interval %s)", m.CollectionDurationMs, sampleEvery)
+		}
+
+		m.HealthScore, m.HealthStatus = healthOf(m)
+		m.Alerts = alerts.eval(m, historyAt)
+		for _, a := range m.Alerts {
+			if a.Severity == "critical" {
+				m.HealthStatus = "red"
+			} else if m.HealthStatus == "green" {
+				m.HealthStatus = "amber"
+			}
+		}
+		recordErrors(m.Timestamp, m.LastError)
+
+		publish(m)
+
+		next = next.Add(sampleEvery)
+		if behind {
+			next = time.Now()
+		} else {
+			time.Sleep(time.Until(next.Add(jitter())))
+		}
+	}
+}
+
+// jitter returns a uniformly random offset in [-sampleJitter, +sampleJitter].
+func jitter() time.Duration {
+	if sampleJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(2*sampleJitter)+1)) - sampleJitter
+}
+
+// longPollMax bounds how long /api/metrics?wait=1 holds a request open.
+const longPollMax = 30 * time.Second
+
+// waitForSample blocks until collectLoop publishes the next sample, the
+// client goes away or longPollMax passes, whichever comes first. ?timeout=
+// shortens the wait.
+func waitForSample(r *http.Request) {
+	d := longPollMax
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if t, err := time.ParseDuration(v); err == nil && t > 0 && t < d {
+			d = t
+		}
+	}
+	ch := subscribe()
+	defer unsubscribe(ch)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ch:
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
+
+// handleMetrics serves the latest sample from the JSON cached by collectLoop.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("format") == "text" {
+		mtx.RLock()
+		m := current
+		mtx.RUnlock()
+		writeMetricsText(w, m)
+		return
+	}
+	if r.URL.Query().Get("delta") == "1" {
+		since, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("since"))
+		if err != nil {
+			http.Error(w, "delta=1 needs since=<timestamp of the last sample you have>", http.StatusBadRequest)
+			return
+		}
+		b, err := metricsDelta(since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+		return
+	}
+	if r.URL.Query().Get("wait") == "1" {
+		waitForSample(r)
+	}
+	mtx.RLock()
+	b, etag := currentJSON, currentETag
+	mtx.RUnlock()
+	human := r.URL.Query().Get("human") == "1"
+	if human && etag != "" {
+		// a different representation of the same sample needs its own
+		// validator, or a client holding one gets a 304 for the other
+		etag = strings.TrimSuffix(etag, `"`) + `-h"`
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if b == nil {
+		// no sample yet (or aggregator mode): keep serving the zero value
+		b, _ = marshalJSON(Metrics{})
+	}
+	if human {
+		if hb, err := humanizeJSON(b); err == nil {
+			b = hb
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// metricsDelta returns the top-level fields of the current sample that differ
+// from the newest history sample at or before since. Fields that disappeared
+// (omitempty lists that went empty) come back as null; timestamp is always
+// included. If since predates the history the full sample is returned.
+func metricsDelta(since time.Time) ([]byte, error) {
+	mtx.RLock()
+	cur := current
+	var base *Metrics
+	for i := len(history) - 1; i >= 0; i-- {
+		if !history[i].Timestamp.After(since) {
+			base = &history[i]
+			break
+		}
+	}
+	var prev Metrics
+	if base != nil {
+		prev = *base
+	}
+	mtx.RUnlock()
+	if base == nil {
+		return marshalJSON(cur)
+	}
+
+	fields := func(m Metrics) (map[string]json.RawMessage, error) {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		var f map[string]json.RawMessage
+		return f, json.Unmarshal(b, &f)
+	}
+	pf, err := fields(prev)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := fields(cur)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]json.RawMessage{"timestamp": cf["timestamp"]}
+	for k, v := range cf {
+		if !bytes.Equal(pf[k], v) {
+			out[k] = v
+		}
+	}
+	for k := range pf {
+		if _, ok := cf[k]; !ok {
+			out[k] = json.RawMessage("null")
+		}
+	}
+	return marshalJSON(out)
+}
+
+type healthStatus struct {
+	Status        string  `json:"status"`
+	LastSampleAge float64 `json:"last_sample_age_sec"`
+	ThresholdSec  float64 `json:"threshold_sec"`
+}
+
+// writeHealth answers /healthz: 503 once the newest sample is older than three
+// intervals, i.e. collectLoop has died or is stuck. Before the first sample the
+// age counts from server start so a fresh process isn't reported as dead.
+func writeHealth(w http.ResponseWriter, started, now time.Time) {
+	mtx.RLock()
+	last := current.Timestamp
+	mtx.RUnlock()
+	if last.IsZero() {
+		last = started
+	}
+	threshold := 3 * sampleEvery
+	age := now.Sub(last)
+	h := healthStatus{Status: "ok", LastSampleAge: age.Seconds(), ThresholdSec: threshold.Seconds()}
+	code := http.StatusOK
+	if age > threshold {
+		h.Status = "stale"
+		code = http.StatusServiceUnavailable
+	}
+	b, _ := marshalJSON(h)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// statusRecorder captures the status code and size for the access log; handlers
+// mostly call Write without an explicit WriteHeader, which means 200.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush/Hijack on the real writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("[access] %s %s %s %d %dB %s", r.RemoteAddr, r.Method, r.URL.RequestURI(), rec.status, rec.size, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// withBasePath serves h under basePath (SYSDASH_BASE_PATH), redirecting the
+// bare prefix to its trailing-slash form; an empty basePath returns h.
+func withBasePath(h http.Handler, basePath string) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	outer := http.NewServeMux()
+	outer.Handle(basePath+"/", http.StripPrefix(basePath, h))
+	outer.Handle(basePath, http.RedirectHandler(basePath+"/", http.StatusMovedPermanently))
+	return outer
+}
+
+// indexHandler serves the embedded UI, injecting the base path into index.html
+// so app.js builds its API URLs under the same prefix.
+func indexHandler(subFS fs.FS, basePath string) http.Handler {
+	static := http.FileServer(http.FS(subFS))
+	raw, err := fs.ReadFile(subFS, "index.html")
+	if err != nil {
+		fatalErr(err, "embedded index.html")
+	}
+	base, _ := json.Marshal(basePath)
+	index := strings.Replace(string(raw), "<head>", "<head>\n  <script>window.SYSDASH_BASE = "+string(base)+";</script>", 1)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			static.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, index)
+	})
+}
+
+// servePprof exposes profiling on its own loopback-only listener so it is never
+// reachable through the dashboard's address, whatever -bind says.
+func servePprof() {
+	port := os.Getenv("SYSDASH_PPROF_PORT")
+	if port == "" {
+		port = "6060"
+	}
+	addr := net.JoinHostPort("127.0.0.1", port)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	log.Printf("pprof listening on http://%s/debug/pprof/", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logErr(err, "[pprof] server stopped")
+	}
+}
+
+func main() {
+	setupLogging()
+	// New: support port flag/env
+	port := flag.String("port", "", "Port to listen on (default 8080 or from SYSDASH_PORT)")
+	aggregate := flag.Bool("aggregate", false, "Run as a fleet aggregator: accept pushed samples instead of collecting locally")
+	bind := flag.String("bind", "", "Full listen address, e.g. 127.0.0.1:8081 or [::1]:9000 (overrides -port, or from SYSDASH_BIND)")
+	pprofOn := flag.Bool("pprof", false, "Serve net/http/pprof on 127.0.0.1 (port from SYSDASH_PPROF_PORT, default 6060)")
+	once := flag.Bool("once", false, "Print a single sample as JSON to stdout and exit")
+	validate := flag.Bool("validate", false, "Check the configuration, print the resolved values and exit (non-zero on problems)")
+	flag.BoolVar(&noFile, "no-file", envBool("SYSDASH_NO_FILE"), "Don't write the JSON file; serve samples from memory only (or SYSDASH_NO_FILE=1)")
+	flag.Parse()
+
+	// -validate reports bad settings through validateConfig; stopping at the
+	// first one here would keep it from ever printing its report
+	configFatal := fatalErr
+	if *validate {
+		configFatal = func(error, string, ...any) {}
+	}
+	if v := os.Getenv("SYSDASH_OUTDIR"); v != "" {
+		dir, err := expandPath(v)
+		if err != nil {
+			configFatal(err, "SYSDASH_OUTDIR")
+		} else {
+			outDir = dir
+		}
+	}
+	if v := os.Getenv("SYSDASH_PROC_ROOT"); v != "" {
+		procRoot = v
+	}
+	if v := os.Getenv("SYSDASH_SYS_ROOT"); v != "" {
+		sysRoot = v
+	}
+	if v := os.Getenv("SYSDASH_OUTFILE"); v != "" {
+		outFile = v
+	}
+	if err := loadHealthConfig(); err != nil {
+		configFatal(err, "")
+	}
+	if err := loadAlertConfig(); err != nil {
+		configFatal(err, "")
+	}
+	if v := os.Getenv("SYSDASH_TZ"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			logErr(err, "ignoring SYSDASH_TZ=%q", v)
+		} else {
+			tz = loc
+		}
+	}
+	if v := os.Getenv("SYSDASH_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			sampleEvery = d
+		}
+	}
+	switch v := strings.ToLower(os.Getenv("SYSDASH_LOAD_SOURCE")); v {
+	case "", "loadavg":
+	case "psi":
+		loadSource = v
+	default:
+		log.Printf("ignoring unknown SYSDASH_LOAD_SOURCE=%q, using loadavg", v)
+	}
+	if v := os.Getenv("SYSDASH_CPU_EMA"); v != "" {
+		if a, err := strconv.ParseFloat(v, 64); err == nil && a > 0 && a <= 1 {
+			cpuEMAAlpha = a
+		} else {
+			log.Printf("ignoring invalid SYSDASH_CPU_EMA=%q, want 0 < alpha <= 1", v)
+		}
+	}
+	sampleJitter = envDuration("SYSDASH_JITTER", 0)
+	if sampleJitter > sampleEvery/2 {
+		log.Printf("SYSDASH_JITTER %s is more than half the interval, using %s", sampleJitter, sampleEvery/2)
+		sampleJitter = sampleEvery / 2
+	}
+	if v := os.Getenv("SYSDASH_HISTORY_LEN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			historyLen = n
+		}
+	}
+	if v := os.Getenv("SYSDASH_HISTORY_MAX_RESPONSE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			historyMaxResponse = n
+		}
+	}
+	if v := os.Getenv("SYSDASH_HISTORY_DURATION"); v != "" {
+		if os.Getenv("SYSDASH_HISTORY_LEN") != "" {
+			configFatal(nil, "SYSDASH_HISTORY_LEN and SYSDASH_HISTORY_DURATION are mutually exclusive")
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			configFatal(nil, "invalid SYSDASH_HISTORY_DURATION %q", v)
+		} else {
+			historyDuration = d
+		}
+	}
+	durableWrite = envBool("SYSDASH_DURABLE_WRITE")
+	persistEnabled = envBool("SYSDASH_PERSIST")
+	persistRaw = envDuration("SYSDASH_PERSIST_RAW", persistRaw)
+	persistKeep = envDuration("SYSDASH_PERSIST_KEEP", persistKeep)
+	gpuEnabled = envBool("SYSDASH_GPU")
+	switch v := strings.ToLower(os.Getenv("SYSDASH_DISK_TEMP")); v {
+	case "", "0", "false":
+	case "smart":
+		diskTempMode = v
+	default:
+		diskTempMode = "1"
+	}
+	procWatch = envList("SYSDASH_PROC_WATCH")
+	if v := os.Getenv("SYSDASH_TOP_N"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			topN = n
+		}
+	}
+	if v := os.Getenv("SYSDASH_FLOAT_DECIMALS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= -1 && n <= 15 {
+			floatDecimals = n
+		} else {
+			log.Printf("invalid SYSDASH_FLOAT_DECIMALS %q, keeping %d", v, floatDecimals)
+		}
+	}
+	for _, m := range strings.Split(os.Getenv("SYSDASH_DISK_MOUNTS"), ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			diskMounts = append(diskMounts, filepath.Clean(m))
+		}
+	}
+	tempInclude = envList("SYSDASH_TEMP_INCLUDE")
+	tempExclude = envList("SYSDASH_TEMP_EXCLUDE")
+	loadTempNames()
+	if names := envList("SYSDASH_COLLECTORS"); len(names) > 0 {
+		collectors = map[string]bool{}
+		for _, n := range names {
+			if !slices.Contains(collectorNames, n) {
+				log.Printf("ignoring unknown collector %q in SYSDASH_COLLECTORS", n)
+				continue
+			}
+			collectors[n] = true
+		}
+		log.Printf("collectors: %s", strings.Join(slices.Sorted(maps.Keys(collectors)), ","))
+	}
+	netIncludeVirtual = envBool("SYSDASH_NET_INCLUDE_VIRTUAL")
+	bindDevice = strings.TrimSpace(os.Getenv("SYSDASH_BIND_DEVICE"))
+	wsOrigins = envList("SYSDASH_WS_ORIGINS")
+	if v := os.Getenv("SYSDASH_NET_FILTER"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			configFatal(err, "invalid SYSDASH_NET_FILTER %q", v)
+		}
+		netFilter = re
+	}
+	flapWindow = envDuration("SYSDASH_FLAP_WINDOW", flapWindow)
+	warmup = envDuration("SYSDASH_WARMUP", 0)
+	warmupDiscard = envBool("SYSDASH_WARMUP_DISCARD")
+	warmupUntil = time.Now().Add(warmup)
+	forecastMinWindow = envDuration("SYSDASH_FORECAST_MIN_WINDOW", forecastMinWindow)
+	netAlias = map[string]string{}
+	for _, p := range strings.Split(os.Getenv("SYSDASH_NET_ALIAS"), ",") {
+		name, alias, ok := strings.Cut(p, "=")
+		name, alias = strings.TrimSpace(name), strings.TrimSpace(alias)
+		if !ok || name == "" || alias == "" {
+			if strings.TrimSpace(p) != "" {
+				log.Printf("ignoring malformed SYSDASH_NET_ALIAS entry %q, want iface=Name", p)
+			}
+			continue
+		}
+		netAlias[name] = alias
+	}
+	compactJSON = envBool("SYSDASH_COMPACT_JSON")
+	if v := os.Getenv("SYSDASH_CGROUP_MEM"); v != "" {
+		cgroupMem = envBool("SYSDASH_CGROUP_MEM")
+	}
+	uplinkIface = strings.TrimSpace(os.Getenv("SYSDASH_UPLINK_IFACE"))
+	pushURL = os.Getenv("SYSDASH_PUSH_URL")
+	promTextfile = os.Getenv("SYSDASH_PROM_TEXTFILE")
+	if v := os.Getenv("SYSDASH_PUSH_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			pushTimeout = d
+		}
+	}
+	if v := os.Getenv("SYSDASH_COLLECT_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			collectTimeout = d
+		}
+	}
+
+	// -once only prints to stdout, so the output location doesn't matter
+	if *once {
+		s := newSampler()
+		time.Sleep(cpuWarmup)
+		m := s.sample()
+		m.HealthScore, m.HealthStatus = healthOf(m)
+		roundFloats(&m)
+		b, _ := marshalJSON(m)
+		os.Stdout.Write(append(b, '\n'))
+		return
+	}
+
+	if !*validate && !noFile {
+		if err := validateOutput(outDir, outFile); err != nil {
+			fatalErr(err, "invalid output config")
+		}
+	}
+
+	basePath := strings.TrimRight(os.Getenv("SYSDASH_BASE_PATH"), "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		configFatal(nil, "SYSDASH_BASE_PATH must start with '/': %q", basePath)
+	}
+
+	addr := ":8081" // default
+	if envPort := os.Getenv("SYSDASH_PORT"); envPort != "" {
+		addr = fmt.Sprintf(":%s", envPort)
+	}
+	if *port != "" {
+		addr = fmt.Sprintf(":%s", *port)
+	}
+	if *bind == "" {
+		*bind = os.Getenv("SYSDASH_BIND")
+	}
+	if *bind != "" {
+		if _, _, err := net.SplitHostPort(*bind); err != nil {
+			configFatal(err, "invalid bind address %q", *bind)
+		}
+		addr = *bind
+	}
+
+	unixSocket := os.Getenv("SYSDASH_UNIX_SOCKET")
+	if unixSocket != "" {
+		addr = "unix:" + unixSocket
+	}
+
+	if *validate {
+		os.Exit(runValidate(addr))
+	}
+
+	if !noFile {
+		ensureDir(outDir)
+	}
+
+	subFS, err := fs.Sub(webFS, "web")
+	if err != nil {
+		fatalErr(err, "failed to prepare embedded FS")
+	}
+
+	if *aggregate {
+		if v := os.Getenv("SYSDASH_AGGREGATE_STALE"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				hostsStale = d
+			}
+		}
+		if v := os.Getenv("SYSDASH_INGEST_MAX_BYTES"); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+				ingestMaxBytes = n
+			}
+		}
+		if v := os.Getenv("SYSDASH_INGEST_TIMEOUT"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				ingestTimeout = d
+			}
+		}
+		log.Printf("aggregator mode: accepting samples on /api/ingest (stale after %s)", hostsStale)
+		go evictLoop()
+	} else {
+		switch {
+		case persistEnabled && noFile:
+			log.Printf("SYSDASH_PERSIST is set, but -no-file keeps the history in memory only")
+		case persistEnabled:
+			restoreHistory()
+		}
+		go superviseCollect(collectLoop)
+		go writeLoop()
+	}
+	if pushURL != "" {
+		log.Printf("pushing samples to %s (timeout %s)", pushURL, pushTimeout)
+		go pushLoop()
+	}
+
+	if *pprofOn {
+		go servePprof()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", indexHandler(subFS, basePath))
+	mux.HandleFunc("/api/metrics", handleMetrics)
+	mux.HandleFunc("/api/ws", handleWS)
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var fields []string
+		if v := q.Get("fields"); v != "" {
+			var err error
+			if fields, err = parseFields(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var since time.Time
+		if v := q.Get("since"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				http.Error(w, "bad since: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		// the cached full history can be served as is unless it may be
+		// longer than the response cap
+		uncapped := historyMaxResponse == 0 || (historyDuration == 0 && historyLen <= historyMaxResponse)
+		var b []byte
+		var h []Metrics
+		if v := q.Get("resolution"); v == "" {
+			if since.IsZero() && uncapped {
+				b = cachedHistoryJSON()
+			} else {
+				h = snapshotHistory()
+			}
+		} else {
+			res, err := time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, "bad resolution: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if res < sampleEvery {
+				http.Error(w, fmt.Sprintf("resolution must be at least the sampling interval (%s)", sampleEvery), http.StatusBadRequest)
+				return
+			}
+			h = downsampleHistory(res)
+		}
+		if b == nil {
+			h, truncated := limitHistory(h, since, historyMaxResponse)
+			if truncated {
+				w.Header().Set("X-History-Truncated", "true")
+			}
+			b, _ = marshalJSON(h)
+		}
+		if fields != nil {
+			var err error
+			if b, err = projectFields(b, fields); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+	mux.HandleFunc("/api/history.csv", func(w http.ResponseWriter, r *http.Request) {
+		writeHistoryCSV(w, snapshotHistory())
+	})
+	mux.HandleFunc("/api/history.bin", func(w http.ResponseWriter, r *http.Request) {
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				http.Error(w, "bad since: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		h, truncated := limitHistory(snapshotHistory(), since, historyMaxResponse)
+		if truncated {
+			w.Header().Set("X-History-Truncated", "true")
+		}
+		writeHistoryBin(w, h)
+	})
+	mux.HandleFunc("/api/summary", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := marshalJSON(summarize())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+	mux.HandleFunc("/api/disk-forecast", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := marshalJSON(diskForecast(snapshotHistory()))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+	mux.HandleFunc("/api/errors", handleErrors)
+	mux.HandleFunc("/api/top", handleTop)
+	mux.HandleFunc("/api/diag", handleDiag)
+	mux.HandleFunc("/api/snapshot", handleSnapshot)
+	// same bytes as /api/metrics; this used to read the file back from disk,
+	// which raced with writeJSON's rename
+	mux.HandleFunc("/api/metrics.json", handleMetrics)
+	if *aggregate {
+		mux.HandleFunc("/api/ingest", handleIngest)
+		mux.HandleFunc("/api/hosts", handleHosts)
+	}
+	mux.HandleFunc("/api/openapi.json", handleOpenAPI)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !*aggregate && !ready.Load() {
+			if time.Now().Before(warmupUntil) {
+				http.Error(w, "warming up until "+warmupUntil.Format(time.RFC3339), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "no sample collected yet", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	healthzStart := time.Now()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if *aggregate {
+			// nothing is collected locally; being able to answer is the check
+			w.WriteHeader(200)
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		writeHealth(w, healthzStart, time.Now())
+	})
+
+	if historyDuration > 0 {
+		log.Printf("history: keeping samples from the last %s", historyDuration)
+	} else {
+		log.Printf("history: keeping the last %d samples", historyLen)
+	}
+	switch {
+	case jsonLogs:
+		out := ""
+		if !noFile {
+			out = filepath.Join(outDir, outFile)
+		}
+		slog.Info("sysdashd listening", "addr", addr, "interval", sampleEvery.String(), "file", out)
+	case noFile:
+		log.Printf("sysdashd listening on %s, not writing a file (interval %s)", addr, sampleEvery)
+	default:
+		log.Printf("sysdashd listening on %s, writing %s/%s (interval %s)", addr, outDir, outFile, sampleEvery)
+	}
+	// the limiter goes inside the base path, where request paths start
+	// with /api/ again
+	var handler http.Handler = mux
+	if v := os.Getenv("SYSDASH_RATE_LIMIT"); v != "" {
+		rps, err := strconv.ParseFloat(v, 64)
+		if err != nil || rps < 0 {
+			fatalf("invalid SYSDASH_RATE_LIMIT %q", v)
+		}
+		if rps > 0 {
+			log.Printf("rate limiting /api/* to %g req/s per client", rps)
+			handler = newRateLimiter(rps).middleware(handler)
+		}
+	}
+	handler = withBasePath(handler, basePath)
+	extraHeaders, err := responseHeaders()
+	if err != nil {
+		fatalErr(err, "")
+	}
+	if len(extraHeaders) > 0 {
+		handler = withHeaders(extraHeaders, handler)
+	}
+	if envBool("SYSDASH_ACCESS_LOG") {
+		handler = accessLog(handler)
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: envDuration("SYSDASH_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("SYSDASH_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      envDuration("SYSDASH_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       envDuration("SYSDASH_IDLE_TIMEOUT", 120*time.Second),
+	}
+	var ln net.Listener
+	if unixSocket != "" {
+		mode := fs.FileMode(0o660)
+		if v := os.Getenv("SYSDASH_UNIX_SOCKET_MODE"); v != "" {
+			m, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || m > 0o777 {
+				fatalf("invalid SYSDASH_UNIX_SOCKET_MODE %q", v)
+			}
+			mode = fs.FileMode(m)
+		}
+		ln, err = listenUnix(unixSocket, mode)
+	} else {
+		ln, err = listenTCP(addr, bindDevice)
+	}
+	if err != nil {
+		fatalErr(err, "")
+	}
+	if bindDevice != "" && unixSocket == "" {
+		log.Printf("listener bound to device %s", bindDevice)
+	}
+	if v := os.Getenv("SYSDASH_MAX_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			fatalf("invalid SYSDASH_MAX_CONNS %q", v)
+		}
+		if n > 0 {
+			log.Printf("limiting to %d concurrent connections", n)
+			ln = newLimitListener(ln, n)
+		}
+	}
+
+	// on SIGINT/SIGTERM stop accepting, let in-flight requests finish, and
+	// close the listener (which also removes a Unix socket file)
+	stopped := make(chan struct{})
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		<-ctx.Done()
+		stop()
+		log.Printf("shutting down")
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			logErr(err, "shutdown")
+		}
+		close(stopped)
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		fatalErr(err, "")
+	}
+	<-stopped
 }
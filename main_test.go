@@ -0,0 +1,1498 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// withFixture writes files (relative path -> contents) under a temp dir and
+// points procRoot and sysRoot at its proc/ and sys/ subdirectories.
+func withFixture(t *testing.T, files map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	for rel, body := range files {
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldProc, oldSys := procRoot, sysRoot
+	procRoot, sysRoot = filepath.Join(root, "proc"), filepath.Join(root, "sys")
+	t.Cleanup(func() { procRoot, sysRoot = oldProc, oldSys })
+}
+
+func TestParseCPUTimes(t *testing.T) {
+	tests := []struct {
+		name    string
+		stat    string
+		want    CPUTimes
+		wantErr bool
+	}{
+		{
+			name: "full line",
+			stat: "cpu  10 20 30 40 50 60 70 80 90 100\ncpu0 1 2 3 4 5 6 7 8 9 10\nctxt 123\n",
+			want: CPUTimes{User: 10, Nice: 20, System: 30, Idle: 40, IOWait: 50, IRQ: 60, SoftIRQ: 70, Steal: 80, Guest: 90, GuestNice: 100},
+		},
+		{
+			name: "old kernel without steal and guest",
+			stat: "cpu  1 2 3 4 5 6 7\n",
+			want: CPUTimes{User: 1, Nice: 2, System: 3, Idle: 4, IOWait: 5, IRQ: 6, SoftIRQ: 7},
+		},
+		{
+			name:    "no aggregate line",
+			stat:    "cpu0 1 2 3 4\nintr 5\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFixture(t, map[string]string{"proc/stat": tt.stat})
+			got, err := parseCPUTimes()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMem(t *testing.T) {
+	tests := []struct {
+		name                       string
+		meminfo                    string
+		total, avail, swapT, swapF uint64
+		estimated                  bool
+		detail                     MemDetail
+	}{
+		{
+			name: "typical",
+			meminfo: "MemTotal:       16000000 kB\nMemFree:         1000000 kB\nMemAvailable:    8000000 kB\n" +
+				"Buffers:          100000 kB\nSwapTotal:       2000000 kB\nSwapFree:        1500000 kB\n",
+			total: 16000000 * 1024, avail: 8000000 * 1024, swapT: 2000000 * 1024, swapF: 1500000 * 1024,
+		},
+		{
+			name:    "no swap",
+			meminfo: "MemTotal: 1024 kB\nMemAvailable: 512 kB\nSwapTotal: 0 kB\nSwapFree: 0 kB\n",
+			total:   1024 * 1024, avail: 512 * 1024,
+		},
+		{
+			name:    "old kernel without MemAvailable",
+			meminfo: "MemTotal: 4096 kB\nMemFree: 1000 kB\nBuffers: 200 kB\nCached: 800 kB\n",
+			total:   4096 * 1024, avail: 2000 * 1024, estimated: true,
+		},
+		{
+			name: "slab and hugepages",
+			meminfo: "MemTotal: 8192 kB\nMemAvailable: 4096 kB\nSlab: 300 kB\nSReclaimable: 200 kB\nSUnreclaim: 100 kB\n" +
+				"HugePages_Total:      16\nHugePages_Free:        4\nHugepagesize:       2048 kB\n",
+			total: 8192 * 1024, avail: 4096 * 1024,
+			detail: MemDetail{SlabB: 300 * 1024, SlabReclaimableB: 200 * 1024, SlabUnreclaimB: 100 * 1024,
+				HugePagesTotal: 16, HugePagesFree: 4, HugePageSizeB: 2048 * 1024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFixture(t, map[string]string{"proc/meminfo": tt.meminfo})
+			total, avail, swapT, swapF, detail, err := readMem()
+			if estimated := errors.Is(err, errMemEstimated); estimated != tt.estimated || (err != nil && !estimated) {
+				t.Fatalf("err = %v, want estimated=%v", err, tt.estimated)
+			}
+			if total != tt.total || avail != tt.avail || swapT != tt.swapT || swapF != tt.swapF {
+				t.Errorf("got %d/%d/%d/%d, want %d/%d/%d/%d", total, avail, swapT, swapF, tt.total, tt.avail, tt.swapT, tt.swapF)
+			}
+			if detail != tt.detail {
+				t.Errorf("detail: got %+v, want %+v", detail, tt.detail)
+			}
+		})
+	}
+}
+
+func TestReadNetIfaces(t *testing.T) {
+	withFixture(t, map[string]string{
+		"sys/class/net/eth0/operstate":             "up\n",
+		"sys/class/net/eth0/speed":                 "1000\n",
+		"sys/class/net/eth0/statistics/rx_bytes":   "1000\n",
+		"sys/class/net/eth0/statistics/tx_bytes":   "2000\n",
+		"sys/class/net/eth0/statistics/rx_packets": "10\n",
+		"sys/class/net/eth0/statistics/tx_packets": "20\n",
+		"sys/class/net/wlan0/operstate":            "down\n",
+		"sys/class/net/wlan0/speed":                "-1\n",
+		"sys/class/net/tun0/operstate":             "unknown\n",
+	})
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	// Index 0 never matches a real interface, so Addrs() comes back empty
+	ifaces := []net.Interface{
+		{Name: "eth0", MTU: 1500, HardwareAddr: mac, Flags: net.FlagUp},
+		{Name: "wlan0", MTU: 1500},
+		{Name: "tun0", MTU: 1400, Flags: net.FlagUp},
+		{Name: "lo", MTU: 65536, Flags: net.FlagUp | net.FlagLoopback},
+		{Name: "veth1234", MTU: 1500, Flags: net.FlagUp},
+	}
+	defer func(a map[string]string) { netAlias = a }(netAlias)
+	netAlias = map[string]string{"eth0": "LAN"}
+	want := []NetStat{
+		{Name: "eth0", DisplayName: "LAN", RxBytes: 1000, TxBytes: 2000, RxPkts: 10, TxPkts: 20, OperUp: true, MAC: "aa:bb:cc:dd:ee:ff", SpeedMbps: 1000, MTU: 1500},
+		{Name: "wlan0", DisplayName: "wlan0", MTU: 1500},
+		{Name: "tun0", DisplayName: "tun0", OperUp: true, MTU: 1400},
+	}
+	if got := readNetIfaces(ifaces); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestCPUPercent(t *testing.T) {
+	base := CPUTimes{User: 1000, Nice: 10, System: 500, Idle: 8000, IOWait: 100, IRQ: 5, SoftIRQ: 5, Steal: 0}
+	add := func(c CPUTimes, user, system, idle, iowait, steal uint64) CPUTimes {
+		c.User += user
+		c.System += system
+		c.Idle += idle
+		c.IOWait += iowait
+		c.Steal += steal
+		return c
+	}
+	tests := []struct {
+		name      string
+		prev, cur CPUTimes
+		want      float64
+	}{
+		{"half busy", base, add(base, 30, 20, 50, 0, 0), 50},
+		{"iowait counts as idle", base, add(base, 25, 0, 50, 25, 0), 25},
+		{"steal counts as busy", base, add(base, 0, 0, 60, 0, 40), 40},
+		{"no time elapsed", base, base, 0},
+		{"fully saturated", base, add(base, 80, 20, 0, 0, 0), 100},
+		{"fully idle", base, add(base, 0, 0, 100, 0, 0), 0},
+		{"counter reset", base, CPUTimes{User: 5, System: 3, Idle: 40}, 0},
+		{"single counter backwards", base, func() CPUTimes { c := add(base, 10, 0, 10, 0, 0); c.IRQ = 0; return c }(), 0},
+		{"zero value prev", CPUTimes{}, CPUTimes{User: 1, Idle: 3}, 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cpuPercent(tt.prev, tt.cur)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("cpuPercent = %v, want %v", got, tt.want)
+			}
+			if got < 0 || got > 100 {
+				t.Errorf("cpuPercent = %v out of range", got)
+			}
+		})
+	}
+}
+
+func TestCPUBreakdown(t *testing.T) {
+	prev := CPUTimes{User: 100, Idle: 100}
+	cur := CPUTimes{User: 110, Nice: 10, System: 20, Idle: 130, IOWait: 20, Steal: 10}
+	got := cpuBreakdown(prev, cur)
+	want := CPUBreakdown{User: 20, System: 20, IOWait: 20, Steal: 10, Idle: 30, Busy: 50}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestReadTempsUnits(t *testing.T) {
+	withFixture(t, map[string]string{
+		"sys/class/thermal/thermal_zone0/type":        "x86_pkg_temp\n",
+		"sys/class/thermal/thermal_zone0/temp":        "50000\n",
+		"sys/class/thermal/thermal_zone1/type":        "industrial\n",
+		"sys/class/thermal/thermal_zone1/temp":        "250000\n",
+		"sys/class/thermal/thermal_zone2/type":        "broken\n",
+		"sys/class/thermal/thermal_zone2/temp":        "N/A\n",
+		"sys/class/hwmon/hwmon0/name":                 "coretemp\n",
+		"sys/class/hwmon/hwmon0/temp1_input":          "61000\n",
+		"sys/class/hwmon/hwmon0/temp1_label":          "Package id 0\n",
+		"sys/class/hwmon/hwmon0/temp2_input":          "150500\n",
+		"sys/class/hwmon/hwmon1/name":                 "nvme\n",
+		"sys/class/hwmon/hwmon1/temp1_input":          "40000\n",
+		"sys/class/thermal/cooling_device0/cur_state": "0\n",
+	})
+	want := []Temp{
+		{Sensor: "x86_pkg_temp", Label: "x86_pkg_temp", FriendlyName: "CPU Package", C: 50},
+		{Sensor: "industrial", Label: "industrial", FriendlyName: "industrial", C: 250},
+		{Sensor: "coretemp", Label: "Package id 0", FriendlyName: "CPU Package", C: 61},
+		{Sensor: "coretemp", Label: "temp2", FriendlyName: "CPU temp2", C: 150.5},
+	}
+	if got := readTemps(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	// comm with spaces and a ')' must not shift the following fields
+	line := "4242 (my (weird) proc) S 1 4242 4242 0 -1 4194560 500 0 0 0 150 50 0 0 20 0 7 0 12345 104857600 2560 18446744073709551615"
+	p, ticks, ok := parseProcStat(line)
+	if !ok {
+		t.Fatal("parseProcStat failed")
+	}
+	want := ProcStat{Name: "my (weird) proc", State: "S", Threads: 7, RSSB: 2560}
+	if p != want || ticks != 200 {
+		t.Errorf("got %+v ticks %d, want %+v ticks 200", p, ticks, want)
+	}
+	if _, _, ok := parseProcStat("4242 (truncated) S 1 2"); ok {
+		t.Error("short line should not parse")
+	}
+}
+
+func TestTransientReadErr(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&fs.PathError{Op: "read", Path: "/proc/stat", Err: syscall.EINVAL}, true},
+		{&fs.PathError{Op: "read", Path: "/proc/stat", Err: syscall.EAGAIN}, true},
+		{&fs.PathError{Op: "open", Path: "/proc/pressure/cpu", Err: syscall.ENOENT}, false},
+		{&fs.PathError{Op: "open", Path: "/proc/1/fd", Err: syscall.EACCES}, false},
+	} {
+		if got := transientReadErr(tc.err); got != tc.want {
+			t.Errorf("transientReadErr(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestProjectFields(t *testing.T) {
+	if _, err := parseFields("timestamp,nope"); err == nil {
+		t.Error("unknown field should be rejected")
+	}
+	fields, err := parseFields(" cpu_percent, load1 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// output follows SYSDASH_COMPACT_JSON like every other endpoint
+	defer func(c bool) { compactJSON = c }(compactJSON)
+	compactJSON = true
+	b, err := projectFields([]byte(`[{"cpu_percent":12.5,"load1":0.4,"hostname":"h"},{"hostname":"h"}]`), fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"cpu_percent":12.5,"load1":0.4},{}]`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestWriteHealth(t *testing.T) {
+	now := time.Now()
+	defer func(d time.Duration) { sampleEvery, current = d, Metrics{} }(sampleEvery)
+	sampleEvery = 2 * time.Second
+	for _, tc := range []struct {
+		last time.Time
+		want int
+	}{
+		{time.Time{}, http.StatusOK}, // no sample yet, just started
+		{now.Add(-5 * time.Second), http.StatusOK},
+		{now.Add(-7 * time.Second), http.StatusServiceUnavailable},
+	} {
+		current = Metrics{Timestamp: tc.last}
+		rec := httptest.NewRecorder()
+		writeHealth(rec, now, now)
+		if rec.Code != tc.want {
+			t.Errorf("last sample %v ago: got %d, want %d", now.Sub(tc.last), rec.Code, tc.want)
+		}
+	}
+}
+
+func TestReadCores(t *testing.T) {
+	withFixture(t, map[string]string{
+		"proc/stat": "cpu  200 0 100 700 0 0 0 0 0 0\n" +
+			"cpu0 100 0 50 350 0 0 0 0 0 0\n" +
+			"cpu1 100 0 50 350 0 0 0 0 0 0\n",
+		"sys/devices/system/cpu/cpu0/cpufreq/scaling_cur_freq": "3400000\n",
+		"sys/devices/system/cpu/cpu0/topology/core_id":         "0\n",
+		"sys/devices/system/cpu/cpu1/topology/core_id":         "1\n",
+	})
+	cs, err := readCPUStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur := cs.perCPU
+	prev := map[int]CPUTimes{0: {User: 50, System: 25, Idle: 325}, 1: {User: 100, System: 50, Idle: 250}}
+	info, _ := readCoreInfo(cur)
+	temps := []Temp{
+		{Sensor: "coretemp", Label: "Package id 0", C: 70},
+		{Sensor: "coretemp", Label: "Core 1", C: 64},
+	}
+	want := []CoreStat{
+		{Index: 0, Percent: 75, FreqMHz: 3400},
+		{Index: 1, Percent: 0, TempC: 64},
+	}
+	if got := readCores(prev, cur, info, temps); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	defer func(d string) { outDir = d }(outDir)
+	outDir = t.TempDir()
+	if p := validateConfig(":8081"); len(p) != 0 {
+		t.Errorf("clean config reported problems: %v", p)
+	}
+	// 0 disables the server timeouts, the warmup and the jitter
+	t.Setenv("SYSDASH_WRITE_TIMEOUT", "0")
+	t.Setenv("SYSDASH_WARMUP", "0")
+	t.Setenv("SYSDASH_JITTER", "0")
+	if p := validateConfig(":8081"); len(p) != 0 {
+		t.Errorf("zero timeout/warmup/jitter reported problems: %v", p)
+	}
+	t.Setenv("SYSDASH_INTERVAL", "-1s")
+	t.Setenv("SYSDASH_TOP_N", "many")
+	t.Setenv("SYSDASH_JITTER", "soon")
+	p := validateConfig(":99999")
+	if len(p) != 4 {
+		t.Errorf("want 4 problems, got %d: %v", len(p), p)
+	}
+	t.Setenv("SYSDASH_JITTER", "")
+
+	// settings main would otherwise stop on before -validate gets to run
+	defer func(r *rateRule) { alertMemDrop = r }(alertMemDrop)
+	t.Setenv("SYSDASH_INTERVAL", "")
+	t.Setenv("SYSDASH_TOP_N", "")
+	t.Setenv("SYSDASH_ALERT_MEM_DROP", "bogus")
+	t.Setenv("SYSDASH_NET_FILTER", "(")
+	t.Setenv("SYSDASH_HISTORY_LEN", "10")
+	t.Setenv("SYSDASH_HISTORY_DURATION", "1h")
+	if p := validateConfig(":8081"); len(p) != 3 {
+		t.Errorf("want 3 problems, got %d: %v", len(p), p)
+	}
+}
+
+func TestReadDiskTemps(t *testing.T) {
+	withFixture(t, map[string]string{
+		"sys/class/hwmon/hwmon0/name":                  "coretemp\n",
+		"sys/class/hwmon/hwmon0/temp1_input":           "50000\n",
+		"sys/class/hwmon/hwmon1/name":                  "nvme\n",
+		"sys/class/hwmon/hwmon1/temp1_input":           "41850\n",
+		"sys/class/hwmon/hwmon1/device/nvme0n1/size":   "0\n",
+		"sys/class/hwmon/hwmon2/name":                  "drivetemp\n",
+		"sys/class/hwmon/hwmon2/temp1_input":           "33000\n",
+		"sys/class/hwmon/hwmon2/device/block/sdb/size": "0\n",
+	})
+	defer func(m string) { diskTempMode = m }(diskTempMode)
+	diskTempMode = "1"
+	got, err := readDiskTemps()
+	want := []DiskTemp{{Device: "nvme0n1", C: 41.85}, {Device: "sdb", C: 33}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestParseSmartTemp(t *testing.T) {
+	out := `ID# ATTRIBUTE_NAME          FLAG     VALUE WORST THRESH TYPE      UPDATED  WHEN_FAILED RAW_VALUE
+  9 Power_On_Hours          0x0032   087   087   000    Old_age   Always       -       11562
+190 Airflow_Temperature_Cel 0x0022   064   052   040    Old_age   Always       -       36
+194 Temperature_Celsius     0x0022   034   048   000    Old_age   Always       -       34 (Min/Max 20/48)
+`
+	if c, ok := parseSmartTemp(out); !ok || c != 34 {
+		t.Errorf("got %v %v, want 34", c, ok)
+	}
+	if _, ok := parseSmartTemp("Device is in STANDBY mode, exit(2)\n"); ok {
+		t.Error("standby output should have no temperature")
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	defer func() { recentErrors = nil }()
+	t0 := time.Unix(1000, 0)
+	recordErrors(t0, "temps:timeout; disks:/mnt: no such file or directory")
+	recordErrors(t0.Add(time.Second), "")
+	recordErrors(t0.Add(2*time.Second), "temps:timeout")
+	want := []ErrorEvent{
+		{Time: t0, Msg: "disks:/mnt: no such file or directory", Count: 1},
+		{Time: t0.Add(2 * time.Second), Msg: "temps:timeout", Count: 2},
+	}
+	if !reflect.DeepEqual(recentErrors, want) {
+		t.Errorf("got  %+v\nwant %+v", recentErrors, want)
+	}
+	for i := 0; i < 30; i++ {
+		recordErrors(t0, fmt.Sprintf("e%d", i))
+	}
+	if len(recentErrors) != maxRecentErrors || recentErrors[0].Msg != "e10" {
+		t.Errorf("want the newest %d, got %d starting at %q", maxRecentErrors, len(recentErrors), recentErrors[0].Msg)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for n, want := range map[uint64]string{
+		0:              "0 B",
+		1023:           "1023 B",
+		1536:           "1.5 KiB",
+		16750372454:    "15.6 GiB",
+		3 << 40:        "3.0 TiB",
+		math.MaxUint64: "16.0 EiB",
+	} {
+		if got := humanBytes(n); got != want {
+			t.Errorf("humanBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestFlapTracker(t *testing.T) {
+	defer func(w time.Duration) { flapWindow = w }(flapWindow)
+	flapWindow = time.Minute
+	ft := newFlapTracker()
+	t0 := time.Unix(1000, 0)
+	prev := map[string]NetStat{"usb0": {Name: "usb0", OperUp: true, CarrierChanges: 4}, "eth0": {Name: "eth0", OperUp: true}}
+
+	// usb0 dropped and came back between samples; eth0 has no carrier counter but went down
+	cur := []NetStat{{Name: "usb0", OperUp: true, CarrierChanges: 6}, {Name: "eth0", OperUp: false}}
+	ft.update(cur, prev, t0)
+	if cur[0].FlapCount != 2 || !cur[0].LastChange.Equal(t0) || cur[1].FlapCount != 1 {
+		t.Fatalf("got %+v", cur)
+	}
+
+	// quiet sample after the window: old events age out, LastChange stays
+	later := []NetStat{{Name: "usb0", OperUp: true, CarrierChanges: 6}}
+	ft.update(later, map[string]NetStat{"usb0": cur[0]}, t0.Add(2*time.Minute))
+	if later[0].FlapCount != 0 || !later[0].LastChange.Equal(t0) {
+		t.Errorf("got %+v", later[0])
+	}
+	if _, ok := ft.last["eth0"]; ok || len(ft.events) != 1 {
+		t.Errorf("eth0 is gone but still tracked: %v %v", ft.events, ft.last)
+	}
+}
+
+func TestMetricsDelta(t *testing.T) {
+	defer func() { current, history = Metrics{}, nil }()
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := Metrics{Timestamp: t0, Hostname: "h", Load1: 0.5, CPUPercent: 10, LastError: "temps:timeout"}
+	b := Metrics{Timestamp: t0.Add(2 * time.Second), Hostname: "h", Load1: 0.5, CPUPercent: 12}
+	history, current = []Metrics{a, b}, b
+
+	got, err := metricsDelta(t0.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(got, &m); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"timestamp": "2025-01-01T00:00:02Z", "cpu_percent": 12.0, "last_error": nil}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+
+	// older than anything kept: full sample
+	got, _ = metricsDelta(t0.Add(-time.Hour))
+	if err := json.Unmarshal(got, &m); err != nil || m["hostname"] != "h" {
+		t.Errorf("want the full sample, got %s", got)
+	}
+}
+
+func TestReadCPUStatCounters(t *testing.T) {
+	withFixture(t, map[string]string{
+		"proc/stat": "cpu  1 2 3 4 5 6 7 8 0 0\nintr 123456 10 0 3\nctxt 987654\nbtime 1700000000\nprocesses 4242\nprocs_running 2\nprocs_blocked 1\n",
+	})
+	cs, err := readCPUStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.ctxt != 987654 || cs.intr != 123456 || cs.forks != 4242 || cs.running != 2 || cs.blocked != 1 {
+		t.Errorf("got ctxt %d intr %d forks %d running %d blocked %d", cs.ctxt, cs.intr, cs.forks, cs.running, cs.blocked)
+	}
+}
+
+func TestHealthOf(t *testing.T) {
+	base := Metrics{CPUCores: 4, MemTotalB: 100, MemAvailB: 60, CPUPercent: 20, Load1: 1}
+	tests := []struct {
+		name   string
+		mod    func(*Metrics)
+		score  float64
+		status string
+	}{
+		{"idle", func(m *Metrics) {}, 100, "green"},
+		// cpu halfway between warn (70) and crit (95): 0.5 of one of four factors
+		{"busy cpu", func(m *Metrics) { m.CPUPercent = 82.5 }, 87.5, "amber"},
+		{"full disk", func(m *Metrics) { m.Disks = []DiskStat{{UsedPct: 40}, {UsedPct: 97}} }, 75, "red"},
+		// 35% CPU pressure is not 875% load per core: halfway from warn 20 to crit 50
+		{"psi pressure", func(m *Metrics) { m.LoadSource, m.Load1 = "psi", 35 }, 87.5, "amber"},
+		{"psi idle", func(m *Metrics) { m.LoadSource, m.Load1 = "psi", 5 }, 100, "green"},
+	}
+	for _, tt := range tests {
+		m := base
+		tt.mod(&m)
+		score, status := healthOf(m)
+		if math.Abs(score-tt.score) > 1e-9 || status != tt.status {
+			t.Errorf("%s: got %.2f %s, want %.2f %s", tt.name, score, status, tt.score, tt.status)
+		}
+	}
+}
+
+func noHistory(time.Time) (Metrics, bool) { return Metrics{}, false }
+
+func TestAlerterReadOnly(t *testing.T) {
+	a := newAlerter()
+	disks := func(rootRO, bootRO bool) Metrics {
+		return Metrics{Disks: []DiskStat{{Mount: "/", ReadOnly: rootRO}, {Mount: "/boot", ReadOnly: bootRO}}}
+	}
+	if got := a.eval(disks(false, true), noHistory); len(got) != 0 {
+		t.Errorf("read-only from the start should not alert: %+v", got)
+	}
+	got := a.eval(disks(true, true), noHistory)
+	if len(got) != 1 || got[0].Name != "disk_read_only" || got[0].Severity != "critical" {
+		t.Errorf("want one disk_read_only alert for /, got %+v", got)
+	}
+}
+
+func TestHistoryStoreCompact(t *testing.T) {
+	defer func(r, k time.Duration) { persistRaw, persistKeep = r, k }(persistRaw, persistKeep)
+	persistRaw, persistKeep = 10*time.Minute, time.Hour
+	hs := newHistoryStore(t.TempDir())
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// one sample every 30s for the last 90 minutes
+	for i := 180; i >= 0; i-- {
+		ts := now.Add(-time.Duration(i) * 30 * time.Second)
+		if err := hs.append(Metrics{Timestamp: ts, CPUPercent: float64(i % 2 * 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := hs.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rolled, raw int
+	for _, m := range h {
+		switch {
+		case m.Timestamp.Before(now.Add(-persistKeep)):
+			t.Errorf("sample %s is older than persistKeep", m.Timestamp)
+		case m.Timestamp.Before(now.Add(-persistRaw)):
+			rolled++
+			if m.CPUPercent != 5 {
+				t.Errorf("rollup at %s: cpu %.1f, want the average 5", m.Timestamp, m.CPUPercent)
+			}
+		default:
+			raw++
+		}
+	}
+	if rolled != 50 || raw != 21 {
+		t.Errorf("got %d rollups and %d raw samples, want 50 and 21", rolled, raw)
+	}
+}
+
+func TestReadConns(t *testing.T) {
+	hdr := "  sl  local_address rem_address   st tx_queue rx_queue\n"
+	withFixture(t, map[string]string{
+		"proc/net/tcp": hdr +
+			"   0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000\n" +
+			"   1: 0100007F:0CEA 0100007F:D2C4 01 00000000:00000000\n",
+		"proc/net/udp": hdr + "   0: 00000000:0044 00000000:0000 07 00000000:00000000\n",
+		"proc/net/tcp6": hdr +
+			"   0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000\n" +
+			"   1: 00000000000000000000000001000000:0016 00000000000000000000000001000000:C350 01 00000000:00000000\n" +
+			"   2: 00000000000000000000000001000000:0016 00000000000000000000000001000000:C351 01 00000000:00000000\n",
+	})
+	v4, err := readConns("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v4["listen"] != 1 || v4["established"] != 1 || v4["udp"] != 1 {
+		t.Errorf("v4 = %v", v4)
+	}
+	// no udp6 in the fixture: a missing table is skipped, not an error
+	v6, err := readConns("6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v6["listen"] != 1 || v6["established"] != 2 || v6["udp"] != 0 {
+		t.Errorf("v6 = %v", v6)
+	}
+}
+
+func TestLimitHistory(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var h []Metrics
+	for i := range 10 {
+		h = append(h, Metrics{Timestamp: t0.Add(time.Duration(i) * time.Second)})
+	}
+	got, cut := limitHistory(h, time.Time{}, 3)
+	if !cut || len(got) != 3 || !got[0].Timestamp.Equal(t0.Add(7*time.Second)) {
+		t.Errorf("cap: got %d samples from %v, cut=%v", len(got), got[0].Timestamp, cut)
+	}
+	got, cut = limitHistory(h, t0.Add(7*time.Second), 3)
+	if cut || len(got) != 2 {
+		t.Errorf("since: got %d samples, cut=%v; want 2 newer than since", len(got), cut)
+	}
+	if got, _ := limitHistory(h, time.Time{}, 0); len(got) != 10 {
+		t.Errorf("max 0 should not limit, got %d", len(got))
+	}
+}
+
+func TestSlogWriter(t *testing.T) {
+	var buf strings.Builder
+	w := slogWriter{slog.NewJSONHandler(&buf, nil)}
+	fmt.Fprintln(w, "[writeJSON] disk full")
+	fmt.Fprintln(w, "history: keeping the last 120 samples")
+	var lines []map[string]any
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("%q: %v", l, err)
+		}
+		lines = append(lines, m)
+	}
+	if l := lines[0]; l["level"] != "WARN" || l["component"] != "writeJSON" || l["msg"] != "disk full" {
+		t.Errorf("component line: %v", l)
+	}
+	if l := lines[1]; l["level"] != "INFO" || l["component"] != nil {
+		t.Errorf("plain line: %v", l)
+	}
+
+	defer func(j bool, w slogWriter) { jsonLogs, jsonLog = j, w }(jsonLogs, jsonLog)
+	jsonLogs, jsonLog = true, w
+	buf.Reset()
+	logErr(errors.New("connection refused"), "[push] %s (failures: %d)", "http://hub", 3)
+	logAt(slog.LevelError, nil, "invalid SYSDASH_MAX_CONNS %q", []any{"x"})
+	lines = lines[:0]
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("%q: %v", l, err)
+		}
+		lines = append(lines, m)
+	}
+	if l := lines[0]; l["level"] != "WARN" || l["component"] != "push" || l["msg"] != "http://hub (failures: 3)" || l["error"] != "connection refused" {
+		t.Errorf("error line: %v", l)
+	}
+	if l := lines[1]; l["level"] != "ERROR" || l["msg"] != `invalid SYSDASH_MAX_CONNS "x"` || l["error"] != nil {
+		t.Errorf("fatal line: %v", l)
+	}
+}
+
+func TestMetricsLongPoll(t *testing.T) {
+	defer func() { currentJSON, currentETag = nil, "" }()
+	done := make(chan string)
+	go func() {
+		rec := httptest.NewRecorder()
+		handleMetrics(rec, httptest.NewRequest("GET", "/api/metrics?wait=1&timeout=5s", nil))
+		done <- rec.Body.String()
+	}()
+	// wait until the handler has subscribed, then publish the way collectLoop does
+	for {
+		subsMtx.Lock()
+		n := len(subs)
+		subsMtx.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mtx.Lock()
+	currentJSON = []byte(`{"new":true}`)
+	mtx.Unlock()
+	broadcast(currentJSON)
+	select {
+	case body := <-done:
+		if body != `{"new":true}` {
+			t.Errorf("got %s, want the new sample", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("long poll did not return after a new sample")
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/api/metrics?wait=1&timeout=20ms", nil))
+	if time.Since(start) > time.Second || rec.Body.String() != `{"new":true}` {
+		t.Errorf("timeout: returned %q after %s", rec.Body.String(), time.Since(start))
+	}
+}
+
+func TestDiskIORates(t *testing.T) {
+	line := func(name string, rdSect, wrSect, ticks int) string {
+		return fmt.Sprintf("   8       0 %s 100 0 %d 50 200 0 %d 80 0 %d 130\n", name, rdSect, wrSect, ticks)
+	}
+	withFixture(t, map[string]string{
+		"proc/diskstats":      line("sda", 1000, 2000, 500) + line("sda1", 1000, 2000, 500) + line("loop0", 8, 0, 1),
+		"sys/block/sda/dev":   "8:0\n",
+		"sys/block/loop0/dev": "7:0\n",
+	})
+	prev, err := readDiskIO()
+	if err != nil || len(prev) != 1 || prev[0].Device != "sda" {
+		t.Fatalf("want only sda (no partitions or loop devices), got %+v, %v", prev, err)
+	}
+	cur := []DiskIOStat{{Device: "sda", ReadBytes: prev[0].ReadBytes + 2048, WriteBytes: prev[0].WriteBytes, ioTicks: prev[0].ioTicks + 1500}}
+	applyDiskIORates(cur, map[string]DiskIOStat{"sda": prev[0]}, 2)
+	if cur[0].ReadBps != 1024 || cur[0].UtilPercent != 75 {
+		t.Errorf("got %.0f B/s, %.1f%% util; want 1024 and 75", cur[0].ReadBps, cur[0].UtilPercent)
+	}
+	cur[0].ioTicks += 5000
+	applyDiskIORates(cur, map[string]DiskIOStat{"sda": prev[0]}, 2)
+	if cur[0].UtilPercent != 100 {
+		t.Errorf("util should clamp at 100, got %.1f", cur[0].UtilPercent)
+	}
+}
+
+func TestTempFriendlyName(t *testing.T) {
+	defer func(v string) { tempNames["acpitz"] = v }(tempNames["acpitz"])
+	t.Setenv("SYSDASH_TEMP_NAMES", "ACPItz=Case, bogus")
+	loadTempNames()
+	for _, tc := range []struct {
+		t    Temp
+		want string
+	}{
+		{Temp{Sensor: "acpitz", Label: "acpitz"}, "Case"},
+		{Temp{Sensor: "pch_cannonlake", Label: "pch_cannonlake"}, "Chipset"},
+		{Temp{Sensor: "k10temp", Label: "Tctl"}, "CPU Tctl"},
+		{Temp{Sensor: "mystery", Label: "mystery"}, "mystery"},
+	} {
+		if got := tempFriendlyName(tc.t); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestSuperviseCollect(t *testing.T) {
+	defer func(b time.Duration) { collectBackoff = b }(collectBackoff)
+	collectBackoff = time.Millisecond
+	before := collectRestarts.Load()
+	calls := make(chan int, 3)
+	n := 0
+	go superviseCollect(func() {
+		n++
+		calls <- n
+		if n == 1 {
+			panic("boom")
+		}
+		select {} // the restarted loop keeps running
+	})
+	for want := 1; want <= 2; want++ {
+		select {
+		case got := <-calls:
+			if got != want {
+				t.Fatalf("call %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("loop not (re)started, call %d never happened", want)
+		}
+	}
+	if got := collectRestarts.Load() - before; got != 1 {
+		t.Errorf("restarts = %d, want 1", got)
+	}
+}
+
+func TestDiskForecast(t *testing.T) {
+	defer func(w time.Duration) { forecastMinWindow = w }(forecastMinWindow)
+	forecastMinWindow = time.Hour
+	const gb = 1 << 30
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var h []Metrics
+	for i := range 25 {
+		// /media grows 1 GiB an hour, / stays put
+		used := uint64(100+i) * gb
+		h = append(h, Metrics{Timestamp: t0.Add(time.Duration(i) * time.Hour), Disks: []DiskStat{
+			{Mount: "/", TotalB: 50 * gb, FreeB: 40 * gb, AvailB: 38 * gb},
+			{Mount: "/media", TotalB: 1000 * gb, FreeB: 1000*gb - used, AvailB: 1000*gb - used},
+		}})
+	}
+	got := diskForecast(h)
+	if len(got) != 2 {
+		t.Fatalf("got %d forecasts", len(got))
+	}
+	root, media := got[0], got[1]
+	if root.FillRateBytesPerDay == nil || *root.FillRateBytesPerDay != 0 || root.DaysUntilFull != nil {
+		t.Errorf("flat /: %+v", root)
+	}
+	if media.FillRateBytesPerDay == nil || math.Abs(*media.FillRateBytesPerDay-24*gb) > 1 {
+		t.Fatalf("/media rate: %+v", media)
+	}
+	if media.DaysUntilFull == nil || math.Abs(*media.DaysUntilFull-876.0/24) > 1e-6 {
+		t.Errorf("/media days until full: %v", media.DaysUntilFull)
+	}
+	forecastMinWindow = 2 * time.Hour
+	if short := diskForecast(h[:2]); short[1].FillRateBytesPerDay != nil || short[1].Note == "" {
+		t.Errorf("an hour of history is below the 2h minimum, got %+v", short[1])
+	}
+}
+
+func TestCollectorsAllowlist(t *testing.T) {
+	withFixture(t, map[string]string{
+		"proc/meminfo": "MemTotal: 1024 kB\nMemAvailable: 512 kB\n",
+		"proc/loadavg": "0.50 0.40 0.30 1/100 4242\n",
+	})
+	defer func() { collectors = nil }()
+	collectors = map[string]bool{"mem": true, "load": true}
+	m := newSampler().sample()
+	if m.MemTotalB != 1024*1024 || m.Load1 != 0.5 {
+		t.Errorf("enabled collectors missing: mem %d, load %.2f", m.MemTotalB, m.Load1)
+	}
+	// everything else would fail against this fixture, so no errors means
+	// nothing else ran
+	if m.LastError != "" || m.Net != nil || m.Disks != nil || m.UptimeSec != 0 {
+		t.Errorf("disabled collectors ran: last_error=%q net=%v disks=%v", m.LastError, m.Net, m.Disks)
+	}
+}
+
+func TestWritePromTextfile(t *testing.T) {
+	defer func(p string) { promTextfile = p }(promTextfile)
+	promTextfile = filepath.Join(t.TempDir(), "sysdash.prom")
+	writePromTextfile(Metrics{
+		CPUPercent: 12.5,
+		Disks:      []DiskStat{{Mount: "/", Device: "/dev/sda1", UsedPct: 40}},
+		Temps:      []Temp{{Sensor: "acpitz", Label: "acpitz", C: 40}, {Sensor: "acpitz", Label: "acpitz", C: 45}},
+	})
+	b, err := os.ReadFile(promTextfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		"# TYPE sysdash_cpu_percent gauge\nsysdash_cpu_percent 12.5\n",
+		`sysdash_disk_used_pct{mount="/",device="/dev/sda1"} 40` + "\n",
+		`sysdash_temp_celsius{sensor="acpitz",label="acpitz"} 40` + "\n",
+		`sysdash_temp_celsius{sensor="acpitz",label="acpitz #2"} 45` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+	if ents, _ := os.ReadDir(filepath.Dir(promTextfile)); len(ents) != 1 {
+		t.Errorf("temp file left behind: %v", ents)
+	}
+}
+
+func TestHandleTop(t *testing.T) {
+	defer setLastProcs(nil)
+	setLastProcs([]ProcStat{
+		{PID: 1, Name: "busy", CPUPercent: 90, RSSB: 10},
+		{PID: 2, Name: "fat", CPUPercent: 1, RSSB: 1000},
+		{PID: 3, Name: "idle", RSSB: 100},
+	})
+	get := func(q string) (int, []ProcStat) {
+		rec := httptest.NewRecorder()
+		handleTop(rec, httptest.NewRequest("GET", "/api/top?"+q, nil))
+		var ps []ProcStat
+		json.Unmarshal(rec.Body.Bytes(), &ps)
+		return rec.Code, ps
+	}
+	if _, ps := get("by=mem&limit=2"); len(ps) != 2 || ps[0].Name != "fat" || ps[1].Name != "idle" {
+		t.Errorf("by=mem: %+v", ps)
+	}
+	if _, ps := get(""); len(ps) != 3 || ps[0].Name != "busy" {
+		t.Errorf("default: %+v", ps)
+	}
+	for _, q := range []string{"by=name", "limit=0", "limit=x"} {
+		if code, _ := get(q); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", q, code)
+		}
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/pi")
+	t.Setenv("STATE", "/srv/state")
+	for in, want := range map[string]string{
+		"~/.sysdash":        "/home/pi/.sysdash",
+		"~":                 "/home/pi",
+		"$STATE/sysdash":    "/srv/state/sysdash",
+		"${HOME}/x":         "/home/pi/x",
+		"/var/lib/sysdash":  "/var/lib/sysdash",
+		"/data/~user/stats": "/data/~user/stats",
+	} {
+		if got, err := expandPath(in); err != nil || got != want {
+			t.Errorf("expandPath(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := expandPath("$SYSDASH_SURELY_UNSET/x"); err == nil {
+		t.Error("unset variable should be an error")
+	}
+}
+
+func TestEnqueueWriteCoalesces(t *testing.T) {
+	defer func(nf bool) { noFile = nf }(noFile)
+	noFile = false
+	t0 := time.Now()
+	for i := range 3 {
+		enqueueWrite(Metrics{Timestamp: t0.Add(time.Duration(i) * time.Second)})
+	}
+	select {
+	case m := <-writeCh:
+		if !m.Timestamp.Equal(t0.Add(2 * time.Second)) {
+			t.Errorf("queued %v, want the newest sample", m.Timestamp)
+		}
+	default:
+		t.Fatal("nothing queued")
+	}
+	if len(writeCh) != 0 {
+		t.Error("queue should hold a single sample")
+	}
+}
+
+func TestSkipIfaceFilter(t *testing.T) {
+	defer func(re *regexp.Regexp, up string) { netFilter, uplinkIface = re, up }(netFilter, uplinkIface)
+	netFilter, uplinkIface = regexp.MustCompile(`^(eth|wlan)`), "wg0"
+	for name, skip := range map[string]bool{
+		"eth0": false, "wlan0": false, "enp3s0": true, "docker0": true, "wg0": false,
+	} {
+		if got := skipIface(net.Interface{Name: name}); got != skip {
+			t.Errorf("%s: skip=%v, want %v", name, got, skip)
+		}
+	}
+	// the filter replaces the virtual exclusion, so it can opt veth* back in
+	netFilter = regexp.MustCompile(`^veth`)
+	if skipIface(net.Interface{Name: "veth12ab"}) {
+		t.Error("veth12ab matches the filter and should be reported")
+	}
+}
+
+func TestPublishWarmup(t *testing.T) {
+	defer func(u time.Time, d bool) {
+		warmupUntil, warmupDiscard = u, d
+		current, currentJSON, history = Metrics{}, nil, nil
+		ready.Store(false)
+		<-writeCh
+	}(warmupUntil, warmupDiscard)
+	now := time.Now()
+	warmupUntil, warmupDiscard = now.Add(10*time.Second), true
+	history = nil
+	ready.Store(false)
+
+	publish(Metrics{Timestamp: now})
+	if ready.Load() || len(history) != 0 || !current.WarmingUp {
+		t.Errorf("during warmup: ready=%v history=%d warming_up=%v", ready.Load(), len(history), current.WarmingUp)
+	}
+	publish(Metrics{Timestamp: now.Add(11 * time.Second)})
+	if !ready.Load() || len(history) != 1 || current.WarmingUp {
+		t.Errorf("after warmup: ready=%v history=%d warming_up=%v", ready.Load(), len(history), current.WarmingUp)
+	}
+}
+
+func TestListenTCPBindDevice(t *testing.T) {
+	ln, err := listenTCP("127.0.0.1:0", "lo")
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("SO_BINDTODEVICE needs CAP_NET_RAW here")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	if _, err := listenTCP("127.0.0.1:0", "nosuchdev0"); err == nil {
+		t.Error("binding to a missing device should fail")
+	}
+}
+
+func TestLimitListenerClose(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := newLimitListener(inner, 1)
+	c, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := ln.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	// the one slot is taken, so this Accept waits on the semaphore
+	errc := make(chan error)
+	go func() {
+		_, err := ln.Accept()
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ln.Close()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept after Close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock a saturated Accept")
+	}
+}
+
+func TestRunDiag(t *testing.T) {
+	withFixture(t, map[string]string{"proc/meminfo": "MemTotal: 2048 kB\nMemAvailable: 1024 kB\n"})
+	defer func() { collectors = nil }()
+	collectors = map[string]bool{"mem": true}
+	got := map[string]DiagResult{}
+	for _, r := range runDiag() {
+		got[r.Collector] = r
+	}
+	if len(got) != len(collectorNames) {
+		t.Errorf("diag covers %d collectors, want %d", len(got), len(collectorNames))
+	}
+	if r := got["mem"]; !r.OK || !r.Enabled || r.Sample != "total 2.0 MiB, available 1.0 MiB" {
+		t.Errorf("mem: %+v", r)
+	}
+	// temps has no sysfs entries in the fixture and is not enabled, but still runs
+	if r := got["temps"]; r.OK || r.Enabled || !strings.Contains(r.Error, "no sensors found") {
+		t.Errorf("temps: %+v", r)
+	}
+}
+
+func TestRateAlerts(t *testing.T) {
+	defer func(d, l *rateRule) { alertDiskDrop, alertLoadRise = d, l }(alertDiskDrop, alertLoadRise)
+	t.Setenv("SYSDASH_ALERT_DISK_DROP", "10GiB/10m")
+	t.Setenv("SYSDASH_ALERT_LOAD_RISE", "2x/1m")
+	if err := loadAlertConfig(); err != nil {
+		t.Fatal(err)
+	}
+	const gb = 1 << 30
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	past := func(at time.Time) (Metrics, bool) {
+		// 10 minutes ago /var had 50 GiB free, / had 20 GiB; load was 1.5
+		if at.Before(now.Add(-15 * time.Minute)) {
+			return Metrics{}, false
+		}
+		return Metrics{Load1: 1.5, Disks: []DiskStat{{Mount: "/var", AvailB: 50 * gb}, {Mount: "/", AvailB: 20 * gb}}}, true
+	}
+	m := Metrics{Timestamp: now, Load1: 3.2, Disks: []DiskStat{{Mount: "/var", AvailB: 38 * gb}, {Mount: "/", AvailB: 19 * gb}}}
+	got := map[string]string{}
+	for _, a := range newAlerter().eval(m, past) {
+		got[a.Name] = a.Msg
+	}
+	if len(got) != 2 || !strings.Contains(got["disk_drop"], "/var dropped 12.0 GiB") || got["load_rise"] == "" {
+		t.Errorf("got %v", got)
+	}
+
+	// with PSI, Load1 is a percentage: 3% to 8% is noise, 5% to 30% isn't
+	psi := func(was float64) func(time.Time) (Metrics, bool) {
+		return func(time.Time) (Metrics, bool) { return Metrics{Load1: was, LoadSource: "psi"}, true }
+	}
+	alertDiskDrop = nil
+	if a := evalRateRules(Metrics{Timestamp: now, Load1: 8, LoadSource: "psi"}, psi(3)); len(a) != 0 {
+		t.Errorf("psi 3%% -> 8%%: %v", a)
+	}
+	if a := evalRateRules(Metrics{Timestamp: now, Load1: 30, LoadSource: "psi"}, psi(5)); len(a) != 1 || a[0].Msg != "CPU pressure rose from 5.0% to 30.0% in 1m0s" {
+		t.Errorf("psi 5%% -> 30%%: %v", a)
+	}
+	if a := evalRateRules(Metrics{Timestamp: now, Load1: 30, LoadSource: "psi"}, past); len(a) != 0 {
+		t.Errorf("loadavg -> psi should not compare: %v", a)
+	}
+
+	for _, bad := range []string{"10GiB", "10XB/10m", "0/1m", "10GiB/soon"} {
+		if _, err := parseRateRule(bad, false); err == nil {
+			t.Errorf("parseRateRule(%q) should fail", bad)
+		}
+	}
+	if _, err := parseRateRule("1x/1m", true); err == nil {
+		t.Error("a factor of 1 never fires and should be rejected")
+	}
+	if n, _ := parseBytes("1.5GB"); n != 1500000000 {
+		t.Errorf("parseBytes(1.5GB) = %d", n)
+	}
+}
+
+func TestEncodeHistoryBin(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	h := []Metrics{
+		{Timestamp: ts, CPUPercent: 12.5, Load1: 0.75, MemTotalB: 1000, MemAvailB: 250,
+			Net:   []NetStat{{Name: "eth0", RxBps: 100, TxBps: 10}, {Name: "wlan0", RxBps: 50, TxBps: 5}},
+			Disks: []DiskStat{{Mount: "/", UsedPct: 40}, {Mount: "/data", UsedPct: 90}},
+			Temps: []Temp{{Sensor: "acpitz", C: 41}, {Sensor: "x86_pkg_temp", C: 63}}},
+		{Timestamp: ts.Add(time.Minute)},
+	}
+	b := encodeHistoryBin(h)
+	le := binary.LittleEndian
+	if string(b[:4]) != "SDH1" || le.Uint16(b[4:]) != 1 || le.Uint32(b[8:]) != 2 {
+		t.Fatalf("header = %x", b[:12])
+	}
+	size := int(le.Uint16(b[6:]))
+	if len(b) != 12+2*size {
+		t.Fatalf("len = %d, want %d", len(b), 12+2*size)
+	}
+	f := func(rec, i int) float64 {
+		return float64(math.Float32frombits(le.Uint32(b[12+rec*size+4+i*4:])))
+	}
+	if got := le.Uint32(b[12:]); got != 1700000000 {
+		t.Errorf("timestamp = %d", got)
+	}
+	want := []float64{12.5, 0.75, 75, 150, 15, 90, 63}
+	for i, w := range want {
+		if got := f(0, i); got != w {
+			t.Errorf("field %d = %v, want %v", i, got, w)
+		}
+	}
+	if !math.IsNaN(f(1, 6)) {
+		t.Errorf("temp without sensors = %v, want NaN", f(1, 6))
+	}
+}
+
+func TestReadRAID(t *testing.T) {
+	withFixture(t, map[string]string{
+		"proc/mdstat": "Personalities : [raid0] [raid1] [raid6] [raid5] [raid4]\n" +
+			"md1 : active raid5 sdc1[2] sdd1[3](F) sde1[0] sdf1[4](S)\n" +
+			"      1953260544 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [U_U]\n" +
+			"      [=>...................]  recovery =  8.5% (83123456/976630272) finish=120.3min speed=123456K/sec\n" +
+			"      bitmap: 0/8 pages [0KB], 65536KB chunk\n" +
+			"\n" +
+			"md0 : active (auto-read-only) raid1 sdb1[1] sda1[0]\n" +
+			"      976630464 blocks super 1.2 [2/2] [UU]\n" +
+			"\n" +
+			"md2 : active raid0 sdg1[1] sdh1[0]\n" +
+			"      1953260544 blocks super 1.2 512k chunks\n" +
+			"\n" +
+			"unused devices: <none>\n",
+	})
+	got, err := readRAID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RAIDStat{
+		{Name: "md1", State: "active", Level: "raid5", Devices: 3, Active: 2, Failed: 1, Spares: 1, Degraded: true, SyncAction: "recovery", SyncPercent: 8.5},
+		{Name: "md0", State: "active (auto-read-only)", Level: "raid1", Devices: 2, Active: 2},
+		{Name: "md2", State: "active", Level: "raid0", Devices: 2, Active: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readRAID() =\n%+v\nwant\n%+v", got, want)
+	}
+	alerts := newAlerter().eval(Metrics{RAIDArrays: got}, historyAt)
+	if len(alerts) != 1 || alerts[0].Name != "raid_degraded" || alerts[0].Severity != "critical" {
+		t.Errorf("alerts = %+v", alerts)
+	}
+
+	withFixture(t, map[string]string{})
+	if got, err := readRAID(); got != nil || err != nil {
+		t.Errorf("without mdstat = %v, %v", got, err)
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	t.Setenv("SYSDASH_HEADERS", "Referrer-Policy: no-referrer\n\nX-Frame-Options: DENY\n")
+	t.Setenv("SYSDASH_CSP", "default-src 'self'; frame-ancestors https://portal.lan")
+	t.Setenv("SYSDASH_FRAME_OPTIONS", "SAMEORIGIN")
+	h, err := responseHeaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := withHeaders(h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+	}))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/api/metrics", nil))
+	for k, want := range map[string]string{
+		"Referrer-Policy":         "no-referrer",
+		"X-Frame-Options":         "SAMEORIGIN",
+		"Content-Security-Policy": "default-src 'self'; frame-ancestors https://portal.lan",
+		"Content-Type":            "application/json",
+	} {
+		if got := rec.Header().Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+
+	for _, bad := range []string{"no colon here", "Bad Name: x", ": empty"} {
+		t.Setenv("SYSDASH_HEADERS", bad)
+		if _, err := responseHeaders(); err == nil {
+			t.Errorf("SYSDASH_HEADERS=%q: want error", bad)
+		}
+	}
+}
+
+func TestHandleSnapshot(t *testing.T) {
+	withFixture(t, map[string]string{})
+	oldCur, oldHist := current, history
+	defer func() { current, history = oldCur, oldHist }()
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	current = Metrics{Timestamp: ts, Hostname: "nas", Alerts: []Alert{{Name: "raid_degraded", Severity: "critical"}}}
+	history = []Metrics{{Timestamp: ts.Add(-time.Minute)}, current}
+
+	rec := httptest.NewRecorder()
+	handleSnapshot(rec, httptest.NewRequest("GET", "/api/snapshot", nil))
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="sysdash-nas-`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	var s Snapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Current.Hostname != "nas" || len(s.History) != 2 || len(s.Alerts) != 1 || len(s.Diag) != len(diagChecks) {
+		t.Errorf("snapshot = %+v", s)
+	}
+}
+
+func TestRoundFloats(t *testing.T) {
+	defer func(d int) { floatDecimals = d }(floatDecimals)
+	floatDecimals = 2
+	m := Metrics{
+		CPUPercent: 12.333333333333334,
+		Load1:      0.005,
+		Net:        []NetStat{{Name: "eth0", RxBps: 1234.5678}},
+		PSI:        &PSI{CPU: PSIResource{Some: PSILine{Avg10: 1.23456}}},
+		Temps:      []Temp{{C: math.NaN()}},
+		MemDetail:  MemDetail{SlabB: 42},
+	}
+	roundFloats(&m)
+	if m.CPUPercent != 12.33 || m.Load1 != 0.01 || m.Net[0].RxBps != 1234.57 || m.PSI.CPU.Some.Avg10 != 1.23 {
+		t.Errorf("rounded = %v %v %v %v", m.CPUPercent, m.Load1, m.Net[0].RxBps, m.PSI.CPU.Some.Avg10)
+	}
+	if !math.IsNaN(m.Temps[0].C) || m.MemDetail.SlabB != 42 {
+		t.Errorf("NaN or integer changed: %v %v", m.Temps[0].C, m.MemDetail.SlabB)
+	}
+
+	floatDecimals = -1
+	m.CPUPercent = 12.333333333333334
+	roundFloats(&m)
+	if m.CPUPercent != 12.333333333333334 {
+		t.Errorf("-1 rounded to %v", m.CPUPercent)
+	}
+}
+
+func TestReadCgroupMem(t *testing.T) {
+	withFixture(t, map[string]string{
+		"sys/fs/cgroup/memory.max":     "536870912\n",
+		"sys/fs/cgroup/memory.current": "209715200\n",
+		"sys/fs/cgroup/memory.stat":    "anon 100000000\nfile 109715200\ninactive_file 9715200\nactive_file 100000000\n",
+	})
+	limit, used, ok, err := readCgroupMem()
+	if err != nil || !ok || limit != 536870912 || used != 200000000 {
+		t.Errorf("v2 = %d, %d, %v, %v", limit, used, ok, err)
+	}
+
+	withFixture(t, map[string]string{"sys/fs/cgroup/memory.max": "max\n"})
+	if _, _, ok, err := readCgroupMem(); ok || err != nil {
+		t.Errorf("memory.max=max: ok=%v err=%v", ok, err)
+	}
+
+	withFixture(t, map[string]string{
+		"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+		"sys/fs/cgroup/memory/memory.usage_in_bytes": "1000\n",
+	})
+	if _, _, ok, err := readCgroupMem(); ok || err != nil {
+		t.Errorf("v1 unlimited: ok=%v err=%v", ok, err)
+	}
+
+	withFixture(t, map[string]string{})
+	if _, _, ok, err := readCgroupMem(); ok || err != nil {
+		t.Errorf("no cgroup files: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestTempAlertRules(t *testing.T) {
+	defer func(r []tempRule) { tempAlertRules = r }(tempAlertRules)
+	t.Setenv("SYSDASH_TEMP_ALERT", "CPU:80, nvme:70")
+	if err := loadAlertConfig(); err != nil {
+		t.Fatal(err)
+	}
+	m := Metrics{
+		Temps: []Temp{
+			{Sensor: "x86_pkg_temp", FriendlyName: "CPU Package", C: 85},
+			{Sensor: "coretemp", Label: "Core 0", FriendlyName: "CPU Core 0", C: 75},
+			{Sensor: "acpitz", FriendlyName: "Motherboard", C: 95},
+		},
+		DiskTemps: []DiskTemp{{Device: "nvme0n1", C: 72}, {Device: "sda", C: 99}},
+	}
+	var got []string
+	for _, a := range newAlerter().eval(m, historyAt) {
+		if a.Name != "temp_high" || a.Severity != "warning" {
+			t.Errorf("unexpected alert %+v", a)
+		}
+		got = append(got, a.Msg)
+	}
+	want := []string{"CPU Package at 85.0°C (limit 80°C)", "nvme0n1 at 72.0°C (limit 70°C)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("alerts = %q, want %q", got, want)
+	}
+
+	// "*" catches the sensors nothing earlier matched
+	t.Setenv("SYSDASH_TEMP_ALERT", "CPU:90,*:90")
+	if err := loadAlertConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if a := newAlerter().eval(m, historyAt); len(a) != 2 {
+		t.Errorf("with default: %+v", a)
+	}
+
+	for _, bad := range []string{"CPU", "CPU:hot", ":80"} {
+		t.Setenv("SYSDASH_TEMP_ALERT", bad)
+		if err := loadAlertConfig(); err == nil {
+			t.Errorf("SYSDASH_TEMP_ALERT=%q: want error", bad)
+		}
+	}
+}
+
+func TestRateLimitUnderBasePath(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {})
+	h := withBasePath(newRateLimiter(1).middleware(mux), "/homedash")
+	var codes []int
+	for range 3 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/homedash/api/metrics", nil))
+		codes = append(codes, rec.Code)
+	}
+	// a burst of 2 at 1 req/s
+	if want := []int{200, 200, 429}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("codes = %v, want %v", codes, want)
+	}
+}
+
+func TestRateLimitPrune(t *testing.T) {
+	// at one request per 100s the bucket takes 100s to refill, longer than
+	// the usual one-minute idle cutoff
+	rl := &rateLimiter{rate: 0.01, burst: 1, clients: map[string]*bucket{}}
+	if ok, _ := rl.allow("10.0.0.1"); !ok {
+		t.Fatal("first request refused")
+	}
+	last := rl.clients["10.0.0.1"].last
+	rl.prune(last.Add(90 * time.Second))
+	if ok, _ := rl.allow("10.0.0.1"); ok {
+		t.Error("bucket pruned before it refilled, so the client got a fresh burst")
+	}
+	rl.prune(rl.clients["10.0.0.1"].last.Add(101 * time.Second))
+	if len(rl.clients) != 0 {
+		t.Errorf("refilled bucket kept: %v", rl.clients)
+	}
+}
+
+func TestCollectSingleFlight(t *testing.T) {
+	defer func(d time.Duration) { collectTimeout = d }(collectTimeout)
+	collectTimeout = 10 * time.Millisecond
+	release := make(chan struct{})
+	calls := 0
+	hang := func() (int, error) {
+		calls++
+		<-release
+		return 1, nil
+	}
+	if _, err := collect("test/hang", hang); err == nil || err == errStillRunning {
+		t.Fatalf("first call: err = %v, want a timeout", err)
+	}
+	if _, err := collect("test/hang", hang); err != errStillRunning {
+		t.Errorf("second call: err = %v, want errStillRunning", err)
+	}
+	if _, err := collect("test/other", func() (int, error) { return 2, nil }); err != nil {
+		t.Errorf("other key: %v", err)
+	}
+	close(release)
+	for range 100 {
+		if v, err := collect("test/hang", hang); err != errStillRunning {
+			if v != 1 || err != nil || calls != 2 {
+				t.Errorf("after release: %v, %v, %d calls", v, err, calls)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("key never left the in-flight set")
+}
+
+func TestPersistView(t *testing.T) {
+	m := Metrics{
+		Timestamp:  time.Unix(1700000000, 0),
+		CPUPercent: 12.5,
+		MemTotalB:  100,
+		Net:        []NetStat{{Name: "eth0", RxBps: 10, TxBps: 5, MAC: "aa:bb", AddrIPv4: "10.0.0.2", OperUp: true}},
+		Disks:      []DiskStat{{Mount: "/", TotalB: 10, FreeB: 4, AvailB: 3, UsedPct: 60, InodesTotal: 99}},
+		Processes:  []ProcStat{{PID: 1}},
+		Cores:      []CoreStat{{}},
+	}
+	p := persistView(m)
+	if p.CPUPercent != 12.5 || p.MemTotalB != 100 || p.Processes != nil || p.Cores != nil {
+		t.Errorf("persistView = %+v", p)
+	}
+	if want := (NetStat{Name: "eth0", RxBps: 10, TxBps: 5, OperUp: true}); !reflect.DeepEqual(p.Net[0], want) {
+		t.Errorf("net = %+v", p.Net[0])
+	}
+	if d := p.Disks[0]; d.AvailB != 3 || d.UsedPct != 60 || d.InodesTotal != 0 {
+		t.Errorf("disk = %+v", d)
+	}
+}
+
+func TestReadProcsTopIsACopy(t *testing.T) {
+	stat := " S 1 1 1 0 -1 4194560 500 0 0 0 150 50 0 0 20 0 7 0 12345 104857600 2560 18446744073709551615"
+	withFixture(t, map[string]string{
+		"proc/1/stat": "1 (init)" + stat,
+		"proc/2/stat": "2 (sshd)" + stat,
+	})
+	defer func(n int) { topN = n }(topN)
+	topN = 5
+	w, err := readProcs(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.top) != 2 || len(w.all) != 2 {
+		t.Fatalf("top %d, all %d", len(w.top), len(w.all))
+	}
+	// fewer processes than topN used to hand back the same backing array
+	w.top[0].CPUPercent = 99
+	if w.all[0].CPUPercent == 99 {
+		t.Error("top shares its backing array with all")
+	}
+}
+
+func TestMetricsETagPerRepresentation(t *testing.T) {
+	defer func() { currentJSON, currentETag = nil, "" }()
+	mtx.Lock()
+	currentJSON, currentETag = []byte(`{"mem_total_bytes":1024}`), `"abc"`
+	mtx.Unlock()
+	get := func(url, inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		rec := httptest.NewRecorder()
+		handleMetrics(rec, req)
+		return rec
+	}
+	raw, human := get("/api/metrics", "").Header().Get("ETag"), get("/api/metrics?human=1", "").Header().Get("ETag")
+	if raw == human || raw == "" || human == "" {
+		t.Fatalf("ETags raw %q, human %q", raw, human)
+	}
+	if rec := get("/api/metrics?human=1", raw); rec.Code != http.StatusOK {
+		t.Errorf("human=1 with the raw ETag: %d, want 200", rec.Code)
+	}
+	if rec := get("/api/metrics", human); rec.Code != http.StatusOK {
+		t.Errorf("raw with the human ETag: %d, want 200", rec.Code)
+	}
+	if rec := get("/api/metrics?human=1", human); rec.Code != http.StatusNotModified {
+		t.Errorf("human=1 with its own ETag: %d, want 304", rec.Code)
+	}
+}
+
+func TestWSOrigin(t *testing.T) {
+	defer func(o []string) { wsOrigins = o }(wsOrigins)
+	wsOrigins = nil
+	req := func(origin string) *http.Request {
+		r := httptest.NewRequest("GET", "http://nas.lan:8081/api/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+	for origin, want := range map[string]bool{
+		"":                    true,
+		"http://nas.lan:8081": true,
+		"http://evil.example": false,
+		"https://portal.lan":  false,
+		"http://nas.lan:9999": false,
+		"null":                false,
+	} {
+		if got := wsOriginAllowed(req(origin)); got != want {
+			t.Errorf("Origin %q: allowed = %v, want %v", origin, got, want)
+		}
+	}
+	wsOrigins = []string{"https://portal.lan"}
+	if !wsOriginAllowed(req("https://portal.lan")) {
+		t.Error("allow-listed origin rejected")
+	}
+
+	r := req("http://evil.example")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	rec := httptest.NewRecorder()
+	handleWS(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cross-site upgrade: %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The OpenAPI document is generated from the Go types by reflection, so the
+// schemas always match the struct tags; only the endpoint list is maintained
+// by hand below.
+
+type apiEndpoint struct {
+	Method, Path, Summary string
+	Params                []apiParam
+	Body                  any    // request body type for POSTs
+	Resp                  any    // JSON response type; nil for non-JSON responses
+	RespType              string // content type when Resp is nil
+}
+
+type apiParam struct {
+	Name, Description, Type string
+}
+
+var apiEndpoints = []apiEndpoint{
+	{Method: "get", Path: "/api/metrics", Summary: "Latest sample", Resp: Metrics{},
+		Params: []apiParam{
+			{"format", "Set to text for one \"key value\" line per metric", "string"},
+			{"human", "Set to 1 to add *_human siblings (e.g. \"15.6 GiB\") to byte fields", "string"},
+			{"delta", "Set to 1 to return only the fields that changed since the sample at ?since=", "string"},
+			{"since", "RFC 3339 timestamp of the client's last sample, used with delta=1", "string"},
+			{"wait", "Set to 1 to hold the request until the next sample is collected (at most 30s)", "string"},
+			{"timeout", "With wait=1, give up sooner than 30s, e.g. 10s", "string"},
+		}},
+	{Method: "get", Path: "/api/history", Summary: "Recent samples, oldest first", Resp: []Metrics{},
+		Params: []apiParam{
+			{"resolution", "Average CPU/load/memory into buckets of this duration, e.g. 1m", "string"},
+			{"fields", "Comma-separated JSON keys to keep in each sample, e.g. timestamp,cpu_percent", "string"},
+			{"since", "RFC 3339 timestamp; only samples after it are returned", "string"},
+		}},
+	{Method: "get", Path: "/api/history.csv", Summary: "Recent samples as CSV", RespType: "text/csv"},
+	{Method: "get", Path: "/api/history.bin", Summary: "Recent samples in a fixed little-endian layout for microcontrollers. " +
+		"12-byte header: magic \"SDH1\", uint16 version (1), uint16 record size (32), uint32 record count. " +
+		"Each record, oldest first: uint32 Unix seconds, then float32 cpu_percent, load1, memory used percent, " +
+		"rx bytes/s and tx bytes/s summed over interfaces, fullest filesystem used percent, hottest sensor °C (NaN when none). " +
+		"Skip records by the header's record size so later versions can append fields.",
+		RespType: "application/octet-stream",
+		Params: []apiParam{
+			{"since", "RFC 3339 timestamp; only samples after it are returned", "string"},
+		}},
+	{Method: "get", Path: "/api/summary", Summary: "Min/max/avg over the history window", Resp: Summary{}},
+	{Method: "get", Path: "/api/disk-forecast", Summary: "Per-mount fill rate and days until full, fitted over the history", Resp: []DiskForecast{}},
+	{Method: "get", Path: "/api/top", Summary: "Processes from the last sample, sorted and limited per request", Resp: []ProcStat{},
+		Params: []apiParam{
+			{"by", "Sort key: cpu (default), mem, fd or threads", "string"},
+			{"limit", "How many processes to return (default SYSDASH_TOP_N, at most 200)", "integer"},
+		}},
+	{Method: "get", Path: "/api/diag", Summary: "Runs every collector once and reports per collector whether it worked, how long it took, and what it found or why it failed", Resp: []DiagResult{}},
+	{Method: "get", Path: "/api/snapshot", Summary: "Support bundle to attach to a bug report: the current sample, its alerts, the full history, /api/diag results and recent errors, as a JSON download", Resp: Snapshot{}},
+	{Method: "get", Path: "/api/errors", Summary: "The last 20 distinct collector errors, oldest first", Resp: []ErrorEvent{}},
+	{Method: "get", Path: "/api/metrics.json", Summary: "Alias of /api/metrics (kept for existing scrapers)", Resp: Metrics{}},
+	{Method: "post", Path: "/api/ingest", Summary: "Push a sample (aggregator mode only)", Body: Metrics{}, RespType: "text/plain"},
+	{Method: "get", Path: "/api/hosts", Summary: "Latest sample per host (aggregator mode only)", Resp: []HostEntry{}},
+	{Method: "get", Path: "/api/ws", Summary: "WebSocket; each new sample is sent as a JSON text frame", RespType: "application/json"},
+	{Method: "get", Path: "/api/openapi.json", Summary: "This document", RespType: "application/json"},
+	{Method: "get", Path: "/readyz", Summary: "Readiness check; 503 until the first sample after SYSDASH_WARMUP exists", RespType: "text/plain"},
+	{Method: "get", Path: "/healthz", Summary: "Liveness check; 503 when the last sample is older than 3 intervals", Resp: healthStatus{}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = marshalJSON(buildOpenAPI())
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
+
+func buildOpenAPI() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+	for _, e := range apiEndpoints {
+		op := map[string]any{"summary": e.Summary}
+		resp := map[string]any{"description": "OK"}
+		switch {
+		case e.Resp != nil:
+			resp["content"] = map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(e.Resp), schemas)}}
+		case e.RespType != "":
+			resp["content"] = map[string]any{e.RespType: map[string]any{}}
+		}
+		op["responses"] = map[string]any{"200": resp}
+		if e.Body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(e.Body), schemas)}},
+			}
+		}
+		var params []any
+		for _, p := range e.Params {
+			params = append(params, map[string]any{
+				"name": p.Name, "in": "query", "description": p.Description,
+				"schema": map[string]any{"type": p.Type},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		item, _ := paths[e.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[e.Path] = item
+		}
+		item[e.Method] = op
+	}
+	return map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]any{"title": "sysdash", "version": "1"},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns a JSON schema for t, registering named structs under
+// schemas and referring to them by $ref.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		s := schemaFor(t.Elem(), schemas)
+		if _, ref := s["$ref"]; ref {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]any{} // placeholder breaks recursion
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaFor(f.Type, schemas)
+		if o := strings.Split(opts, ","); !slices.Contains(o, "omitempty") && !slices.Contains(o, "omitzero") {
+			required = append(required, name)
+		}
+	}
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
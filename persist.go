@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Persisted history (SYSDASH_PERSIST=1) is an NDJSON file next to the
+// snapshot, one sample per line. It has two tiers: samples younger than
+// persistRaw are kept as collected, older ones are rolled up into
+// persistRollupRes averages and dropped after persistKeep.
+const (
+	persistFile      = "history.ndjson"
+	persistRollupRes = time.Minute
+)
+
+var (
+	persistEnabled bool
+	persistRaw     = time.Hour
+	persistKeep    = 7 * 24 * time.Hour
+	histStore      *historyStore
+)
+
+// persistCh feeds history lines to writeLoop. Unlike writeCh it queues
+// rather than coalesces, since a dropped sample leaves a hole in the file; it
+// only drops when the writer is a minute or more behind.
+var persistCh = make(chan Metrics, 64)
+
+func enqueuePersist(m Metrics) {
+	select {
+	case persistCh <- persistView(m):
+	default:
+		log.Printf("[persist] writer behind, dropping the %s sample", m.Timestamp.Format(time.RFC3339))
+	}
+}
+
+// persistView is the part of a sample worth keeping for days: what the
+// charts, /api/summary, /api/disk-forecast and /api/history.bin read.
+// Processes, cores, addresses and the like made up most of each line.
+func persistView(m Metrics) Metrics {
+	p := Metrics{
+		Timestamp:          m.Timestamp,
+		Hostname:           m.Hostname,
+		UptimeSec:          m.UptimeSec,
+		CPUPercent:         m.CPUPercent,
+		CPUPercentSmoothed: m.CPUPercentSmoothed,
+		Load1:              m.Load1,
+		Load5:              m.Load5,
+		Load15:             m.Load15,
+		MemTotalB:          m.MemTotalB,
+		MemAvailB:          m.MemAvailB,
+		SwapTotalB:         m.SwapTotalB,
+		SwapFreeB:          m.SwapFreeB,
+		UplinkRxBps:        m.UplinkRxBps,
+		UplinkTxBps:        m.UplinkTxBps,
+		HealthScore:        m.HealthScore,
+		HealthStatus:       m.HealthStatus,
+		Temps:              m.Temps,
+	}
+	for _, n := range m.Net {
+		p.Net = append(p.Net, NetStat{Name: n.Name, OperUp: n.OperUp, RxBps: n.RxBps, TxBps: n.TxBps})
+	}
+	for _, d := range m.Disks {
+		p.Disks = append(p.Disks, DiskStat{Mount: d.Mount, Device: d.Device, TotalB: d.TotalB, FreeB: d.FreeB, AvailB: d.AvailB, UsedPct: d.UsedPct})
+	}
+	return p
+}
+
+// restoreHistory opens the store and seeds the in-memory history from it, so
+// a restart does not leave the charts empty.
+func restoreHistory() {
+	histStore = newHistoryStore(outDir)
+	h, err := histStore.load()
+	if err != nil {
+		logErr(err, "[restoreHistory] read failed")
+		return
+	}
+	if len(h) == 0 {
+		return
+	}
+	mtx.Lock()
+	history = h
+	trimHistory(time.Now())
+	mtx.Unlock()
+	log.Printf("history: restored %d samples from %s", len(h), histStore.path)
+}
+
+type historyStore struct {
+	path        string
+	lastCompact time.Time
+}
+
+func newHistoryStore(dir string) *historyStore {
+	return &historyStore{path: filepath.Join(dir, persistFile)}
+}
+
+// append adds one line to the file and compacts it once per rollup period,
+// so the file grows by at most a minute of raw samples between rewrites. It
+// runs on writeLoop, never on the sampling goroutine.
+func (hs *historyStore) append(m Metrics) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(hs.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if m.Timestamp.Sub(hs.lastCompact) < persistRollupRes {
+		return nil
+	}
+	hs.lastCompact = m.Timestamp
+	return hs.compact(m.Timestamp)
+}
+
+// load returns every sample in the file, oldest first. Lines that do not
+// parse (a torn write after a crash) are skipped.
+func (hs *historyStore) load() ([]Metrics, error) {
+	b, err := os.ReadFile(hs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Metrics
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(nil, 1<<24)
+	for sc.Scan() {
+		var m Metrics
+		if json.Unmarshal(sc.Bytes(), &m) == nil {
+			out = append(out, m)
+		}
+	}
+	return out, sc.Err()
+}
+
+// compact rewrites the file with whole minutes older than persistRaw rolled
+// up. The cutoff is minute-aligned so a bucket is always rolled up in one
+// pass, and rolling up an existing rollup again leaves it unchanged.
+func (hs *historyStore) compact(now time.Time) error {
+	h, err := hs.load()
+	if err != nil {
+		return err
+	}
+	cutoff := now.Add(-persistRaw).Truncate(persistRollupRes)
+	keepFrom := now.Add(-persistKeep)
+	var old, raw []Metrics
+	for _, m := range h {
+		switch {
+		case m.Timestamp.Before(keepFrom):
+		case m.Timestamp.Before(cutoff):
+			old = append(old, m)
+		default:
+			raw = append(raw, m)
+		}
+	}
+	var buf bytes.Buffer
+	for _, m := range append(downsample(old, persistRollupRes), raw...) {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	tmp := hs.path + ".tmp"
+	if err := writeTemp(tmp, buf.Bytes()); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, hs.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
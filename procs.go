@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ProcStat struct {
+	PID        int     `json:"pid"`
+	Name       string  `json:"name"`
+	State      string  `json:"state"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSSB       uint64  `json:"rss_bytes"`
+	Threads    int     `json:"threads"`
+	FDCount    int     `json:"fd_count"`
+}
+
+// WatchedProc aggregates every process whose name matches a SYSDASH_PROC_WATCH
+// entry, so a service shows up even when it is not in the top list.
+type WatchedProc struct {
+	Match      string  `json:"match"`
+	Count      int     `json:"count"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSSB       uint64  `json:"rss_bytes"`
+	Threads    int     `json:"threads"`
+}
+
+const clkTck = 100 // USER_HZ; 100 on every mainstream Linux arch
+
+var (
+	topN = 10
+	// lowercased comm substrings from SYSDASH_PROC_WATCH
+	procWatch []string
+)
+
+// procTicks is utime+stime per PID from the previous walk, used to turn the
+// cumulative counters into a CPU percentage.
+type procTicks map[int]uint64
+
+// procWalk is the result of one pass over /proc.
+type procWalk struct {
+	top                     []ProcStat
+	all                     []ProcStat // every process, CPU-sorted, for /api/top
+	watched                 []WatchedProc
+	ticks                   procTicks
+	procs, threads, zombies int
+}
+
+// readProcs walks /proc/[pid]/stat and returns the topN processes by CPU
+// (percent of one core over the last elapsed seconds), the watched-process
+// totals, and this walk's tick counters for the next call. It never mutates
+// prev, so an abandoned (timed out) walk can't race with the next one.
+func readProcs(prev procTicks, elapsed float64) (procWalk, error) {
+	entries, err := os.ReadDir(procPath())
+	if err != nil {
+		return procWalk{ticks: prev}, err
+	}
+	page := uint64(os.Getpagesize())
+	w := procWalk{ticks: make(procTicks, len(prev))}
+	for _, m := range procWatch {
+		w.watched = append(w.watched, WatchedProc{Match: m})
+	}
+	var all []ProcStat
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		raw, err := os.ReadFile(procPath(e.Name(), "stat"))
+		if err != nil {
+			continue // exited mid-walk
+		}
+		p, t, ok := parseProcStat(string(raw))
+		if !ok {
+			continue
+		}
+		p.PID = pid
+		p.RSSB *= page
+		w.ticks[pid] = t
+		w.procs++
+		// num_threads matches the /proc/[pid]/task entry count without another readdir
+		w.threads += p.Threads
+		if p.State == "Z" {
+			w.zombies++
+		}
+		if last, seen := prev[pid]; seen && t >= last && elapsed > 0 {
+			p.CPUPercent = float64(t-last) / clkTck / elapsed * 100
+		}
+		all = append(all, p)
+		if len(w.watched) > 0 {
+			name := strings.ToLower(p.Name)
+			for i := range w.watched {
+				if wp := &w.watched[i]; strings.Contains(name, wp.Match) {
+					wp.Count++
+					wp.CPUPercent += p.CPUPercent
+					wp.RSSB += p.RSSB
+					wp.Threads += p.Threads
+				}
+			}
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].CPUPercent != all[j].CPUPercent {
+			return all[i].CPUPercent > all[j].CPUPercent
+		}
+		return all[i].RSSB > all[j].RSSB
+	})
+	w.all = all
+	// always a copy: publish rounds the sample's fields in place while
+	// /api/top reads lastProcs
+	all = slices.Clone(all[:min(len(all), topN)])
+	// only the listed processes pay for an fd directory scan
+	for i := range all {
+		all[i].FDCount = countFDs(all[i].PID)
+	}
+	w.top = all
+	return w, nil
+}
+
+// parseProcStat parses /proc/[pid]/stat. The comm field is in parentheses and
+// may itself contain spaces or ')', so split on the last ')'. RSS is in pages.
+func parseProcStat(s string) (p ProcStat, ticks uint64, ok bool) {
+	open := strings.IndexByte(s, '(')
+	closing := strings.LastIndexByte(s, ')')
+	if open < 0 || closing < open {
+		return p, 0, false
+	}
+	p.Name = s[open+1 : closing]
+	// rest[0] is field 3 (state), so field N is rest[N-3]
+	rest := strings.Fields(s[closing+1:])
+	if len(rest) < 22 {
+		return p, 0, false
+	}
+	p.State = rest[0]
+	utime, _ := strconv.ParseUint(rest[11], 10, 64)
+	stime, _ := strconv.ParseUint(rest[12], 10, 64)
+	p.Threads, _ = strconv.Atoi(rest[17])
+	p.RSSB, _ = strconv.ParseUint(rest[21], 10, 64)
+	return p, utime + stime, true
+}
+
+// countFDs returns the number of open descriptors, or -1 when /proc/[pid]/fd
+// is not readable (another user's process without CAP_SYS_PTRACE).
+func countFDs(pid int) int {
+	f, err := os.Open(procPath(strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return -1
+	}
+	return len(names)
+}
+
+// lastProcs is procWalk.all from the latest sample, served by /api/top.
+var (
+	lastProcsMtx sync.Mutex
+	lastProcs    []ProcStat
+)
+
+func setLastProcs(all []ProcStat) {
+	lastProcsMtx.Lock()
+	lastProcs = all
+	lastProcsMtx.Unlock()
+}
+
+const topMaxLimit = 200
+
+// topSortKeys are the orders /api/top accepts in ?by=, highest first.
+var topSortKeys = map[string]func(p ProcStat) float64{
+	"cpu":     func(p ProcStat) float64 { return p.CPUPercent },
+	"mem":     func(p ProcStat) float64 { return float64(p.RSSB) },
+	"fd":      func(p ProcStat) float64 { return float64(p.FDCount) },
+	"threads": func(p ProcStat) float64 { return float64(p.Threads) },
+}
+
+// topProcs sorts the last sample's processes by key and returns the first
+// limit. CPU and memory come from the sample; descriptor counts are read now,
+// for every process when sorting by fd and for the returned ones otherwise.
+func topProcs(by string, limit int) []ProcStat {
+	lastProcsMtx.Lock()
+	all := slices.Clone(lastProcs)
+	lastProcsMtx.Unlock()
+	if by == "fd" {
+		for i := range all {
+			all[i].FDCount = countFDs(all[i].PID)
+		}
+	}
+	key := topSortKeys[by]
+	sort.SliceStable(all, func(i, j int) bool { return key(all[i]) > key(all[j]) })
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	if by != "fd" {
+		for i := range all {
+			all[i].FDCount = countFDs(all[i].PID)
+		}
+	}
+	return all
+}
+
+// handleTop serves /api/top?by=cpu|mem|fd|threads&limit=N.
+func handleTop(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "cpu"
+	}
+	if _, ok := topSortKeys[by]; !ok {
+		http.Error(w, "by must be one of cpu, mem, fd, threads", http.StatusBadRequest)
+		return
+	}
+	limit := topN
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, topMaxLimit)
+	}
+	b, _ := marshalJSON(topProcs(by, limit))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
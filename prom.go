@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// promTextfile (SYSDASH_PROM_TEXTFILE) is a path that receives every sample
+// in the Prometheus exposition format, for node_exporter's textfile
+// collector. It is written in addition to the JSON file, even with -no-file.
+var (
+	promTextfile string
+	promLastErr  string
+)
+
+// promText renders m in the Prometheus text format. Names carry a sysdash_
+// prefix so they can't clash with node_exporter's own node_* series.
+func promText(m Metrics) []byte {
+	var b strings.Builder
+	family := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP sysdash_%s %s\n# TYPE sysdash_%s %s\n", name, help, name, typ)
+	}
+	sample := func(name string, v float64, labels ...string) {
+		b.WriteString("sysdash_" + name)
+		if len(labels) > 0 {
+			b.WriteByte('{')
+			for i := 0; i+1 < len(labels); i += 2 {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(&b, "%s=\"%s\"", labels[i], promEscape.Replace(labels[i+1]))
+			}
+			b.WriteByte('}')
+		}
+		b.WriteString(" " + strconv.FormatFloat(v, 'g', -1, 64) + "\n")
+	}
+	gauge := func(name, help string, v float64) {
+		family(name, "gauge", help)
+		sample(name, v)
+	}
+
+	gauge("uptime_seconds", "Seconds since boot.", float64(m.UptimeSec))
+	gauge("cpu_percent", "CPU busy percent over the last interval.", m.CPUPercent)
+	gauge("cpu_iowait_percent", "CPU iowait percent over the last interval.", m.CPUIOWaitPercent)
+	gauge("cpu_steal_percent", "CPU steal percent over the last interval.", m.CPUStealPercent)
+	gauge("cpu_cores", "Number of logical CPUs.", float64(m.CPUCores))
+	gauge("load1", "1-minute load average.", m.Load1)
+	gauge("load5", "5-minute load average.", m.Load5)
+	gauge("load15", "15-minute load average.", m.Load15)
+	gauge("mem_total_bytes", "Total memory.", float64(m.MemTotalB))
+	gauge("mem_available_bytes", "Memory available for new work.", float64(m.MemAvailB))
+	gauge("swap_total_bytes", "Total swap.", float64(m.SwapTotalB))
+	gauge("swap_free_bytes", "Free swap.", float64(m.SwapFreeB))
+	gauge("health_score", "Weighted health score, 100 is healthy.", m.HealthScore)
+	gauge("process_count", "Number of processes.", float64(m.ProcessCount))
+	gauge("zombie_count", "Number of zombie processes.", float64(m.ZombieCount))
+
+	if len(m.Net) > 0 {
+		family("net_rx_bps", "gauge", "Received bytes per second.")
+		for _, n := range m.Net {
+			sample("net_rx_bps", n.RxBps, "iface", n.Name)
+		}
+		family("net_tx_bps", "gauge", "Transmitted bytes per second.")
+		for _, n := range m.Net {
+			sample("net_tx_bps", n.TxBps, "iface", n.Name)
+		}
+	}
+	if len(m.Disks) > 0 {
+		family("disk_used_pct", "gauge", "Filesystem space used, percent.")
+		for _, d := range m.Disks {
+			sample("disk_used_pct", d.UsedPct, "mount", d.Mount, "device", d.Device)
+		}
+		family("disk_avail_bytes", "gauge", "Filesystem space available to unprivileged users.")
+		for _, d := range m.Disks {
+			sample("disk_avail_bytes", float64(d.AvailB), "mount", d.Mount, "device", d.Device)
+		}
+	}
+	if len(m.DiskIO) > 0 {
+		family("disk_util_percent", "gauge", "Share of time the device had I/O in flight.")
+		for _, d := range m.DiskIO {
+			sample("disk_util_percent", d.UtilPercent, "device", d.Device)
+		}
+	}
+	if len(m.Temps) > 0 {
+		family("temp_celsius", "gauge", "Temperature sensor reading.")
+		// several thermal zones can share a type; node_exporter rejects a
+		// file with duplicate series, so number the repeats
+		seen := map[string]int{}
+		for _, t := range m.Temps {
+			label := t.Label
+			if n := seen[t.Sensor+"\x00"+label]; n > 0 {
+				label = fmt.Sprintf("%s #%d", label, n+1)
+			}
+			seen[t.Sensor+"\x00"+t.Label]++
+			sample("temp_celsius", t.C, "sensor", t.Sensor, "label", label)
+		}
+	}
+	return []byte(b.String())
+}
+
+// promEscape escapes a label value as the exposition format requires.
+var promEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePromTextfile replaces promTextfile atomically; the temp name doesn't
+// end in .prom, so the collector never reads a half-written file. Failures
+// are logged when they change rather than every interval.
+func writePromTextfile(m Metrics) {
+	tmp := filepath.Join(filepath.Dir(promTextfile), "."+filepath.Base(promTextfile)+".tmp")
+	err := writeTemp(tmp, promText(m))
+	if err == nil {
+		err = os.Rename(tmp, promTextfile)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		if err.Error() != promLastErr {
+			logErr(err, "[promTextfile] write failed")
+		}
+		promLastErr = err.Error()
+		return
+	}
+	if promLastErr != "" {
+		log.Printf("[promTextfile] recovered")
+		promLastErr = ""
+	}
+}
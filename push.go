@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Optional remote push: when SYSDASH_PUSH_URL is set every completed sample is
+// POSTed as JSON to a central collector (see -aggregate). Pushing happens on its
+// own goroutine so a slow or dead collector never delays sampling.
+var (
+	pushURL      string
+	pushTimeout  = 3 * time.Second
+	pushCh       = make(chan Metrics, 1)
+	pushFailures atomic.Uint64
+)
+
+// enqueuePush hands a sample to pushLoop. If the previous sample is still queued
+// it is replaced, so a backlog never builds up behind a slow collector.
+func enqueuePush(m Metrics) {
+	if pushURL == "" {
+		return
+	}
+	for {
+		select {
+		case pushCh <- m:
+			return
+		default:
+		}
+		select {
+		case <-pushCh:
+		default:
+		}
+	}
+}
+
+func pushLoop() {
+	client := &http.Client{Timeout: pushTimeout}
+	for m := range pushCh {
+		b, err := json.Marshal(m)
+		if err != nil {
+			logErr(err, "[push] marshal")
+			continue
+		}
+		// retry once after a short pause; transient blips are common on wifi
+		if err = postSample(client, b); err != nil {
+			time.Sleep(500 * time.Millisecond)
+			err = postSample(client, b)
+		}
+		if err != nil {
+			n := pushFailures.Add(1)
+			logErr(err, "[push] %s (failures: %d)", pushURL, n)
+		}
+	}
+}
+
+func postSample(client *http.Client, body []byte) error {
+	resp, err := client.Post(pushURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
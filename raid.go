@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RAIDStat is one md array from /proc/mdstat. Devices is how many members
+// the array should have and Active how many are working ([2/1] [U_]); a
+// failed member (sdb1[1](F)) counts toward Failed, a spare toward Spares.
+// SyncAction is resync, recovery, reshape, check or repair while one runs.
+type RAIDStat struct {
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	Level       string  `json:"level,omitempty"`
+	Devices     int     `json:"devices"`
+	Active      int     `json:"active"`
+	Failed      int     `json:"failed"`
+	Spares      int     `json:"spares"`
+	Degraded    bool    `json:"degraded"`
+	SyncAction  string  `json:"sync_action,omitempty"`
+	SyncPercent float64 `json:"sync_percent,omitempty"`
+}
+
+var (
+	mdCountsRe = regexp.MustCompile(`\[(\d+)/(\d+)\]`)
+	mdSyncRe   = regexp.MustCompile(`\b(resync|recovery|reshape|check|repair)\s*=\s*([\d.]+)%`)
+)
+
+// readRAID parses /proc/mdstat. Without the md driver there is no such file,
+// which yields no arrays rather than an error.
+func readRAID() ([]RAIDStat, error) {
+	b, err := readRetry(procPath("mdstat"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []RAIDStat
+	var cur *RAIDStat
+	for _, line := range strings.Split(string(b), "\n") {
+		name, rest, ok := strings.Cut(line, " : ")
+		if ok && strings.HasPrefix(name, "md") {
+			// md0 : active raid1 sdb1[1] sda1[0](F)
+			out = append(out, RAIDStat{Name: name})
+			cur = &out[len(out)-1]
+			f := strings.Fields(rest)
+			if len(f) > 0 {
+				cur.State = f[0]
+				f = f[1:]
+			}
+			for _, w := range f {
+				switch {
+				case strings.HasPrefix(w, "("):
+					// "(auto-read-only)", "(read-only)"
+					cur.State += " " + w
+				case !strings.Contains(w, "["):
+					cur.Level = w
+				case strings.HasSuffix(w, "(F)"):
+					cur.Failed++
+				case strings.HasSuffix(w, "(S)"):
+					cur.Spares++
+				default:
+					// raid0 and linear have no [n/m] line; every member counts
+					cur.Devices++
+					cur.Active++
+				}
+			}
+			continue
+		}
+		if cur == nil || !strings.HasPrefix(line, " ") {
+			cur = nil
+			continue
+		}
+		if c := mdCountsRe.FindStringSubmatch(line); c != nil {
+			cur.Devices, _ = strconv.Atoi(c[1])
+			cur.Active, _ = strconv.Atoi(c[2])
+			cur.Degraded = cur.Active < cur.Devices
+		}
+		if s := mdSyncRe.FindStringSubmatch(line); s != nil {
+			cur.SyncAction = s[1]
+			cur.SyncPercent, _ = strconv.ParseFloat(s[2], 64)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per-client token bucket for /api/*. A stuck browser tab polling in a tight
+// loop gets 429s instead of making the server marshal history nonstop.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	clients map[string]*bucket
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	rl := &rateLimiter{rate: rps, burst: math.Max(1, rps*2), clients: map[string]*bucket{}}
+	go rl.cleanupLoop()
+	return rl
+}
+
+// allow takes a token for key, or reports how long until one is available.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	b, ok := rl.clients[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.clients[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanupLoop forgets clients whose bucket has been full for a while.
+func (rl *rateLimiter) cleanupLoop() {
+	for now := range time.Tick(time.Minute) {
+		rl.prune(now)
+	}
+}
+
+// prune drops the buckets idle long enough to have refilled, which takes
+// burst/rate: forgetting one earlier would hand a slow-rate client a fresh
+// burst.
+func (rl *rateLimiter) prune(now time.Time) {
+	idle := max(time.Minute, time.Duration(rl.burst/rl.rate*float64(time.Second)))
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for k, b := range rl.clients {
+		if now.Sub(b.last) > idle {
+			delete(rl.clients, k)
+		}
+	}
+}
+
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ok, wait := rl.allow(host); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
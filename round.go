@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"reflect"
+)
+
+// floatDecimals (SYSDASH_FLOAT_DECIMALS) is how many decimals float metrics
+// keep in everything served or written; -1 keeps full precision. Rounding
+// happens once when a sample is published, so /api/metrics, the history and
+// the file all carry the same values.
+var floatDecimals = 2
+
+// roundFloats rounds every float field reachable from v, which must be a
+// pointer, to floatDecimals decimals.
+func roundFloats(v any) {
+	if floatDecimals < 0 {
+		return
+	}
+	roundValue(reflect.ValueOf(v), math.Pow10(floatDecimals))
+}
+
+func roundValue(v reflect.Value, scale float64) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if v.CanSet() {
+			v.SetFloat(roundTo(v.Float(), scale))
+		}
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			roundValue(v.Elem(), scale)
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				roundValue(v.Field(i), scale)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			roundValue(v.Index(i), scale)
+		}
+	case reflect.Map:
+		// map values aren't addressable; only float values need rewriting
+		if k := v.Type().Elem().Kind(); k == reflect.Float32 || k == reflect.Float64 {
+			for _, key := range v.MapKeys() {
+				v.SetMapIndex(key, reflect.ValueOf(roundTo(v.MapIndex(key).Float(), scale)).Convert(v.Type().Elem()))
+			}
+		}
+	}
+}
+
+func roundTo(f, scale float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	return math.Round(f*scale) / scale
+}
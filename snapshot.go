@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Snapshot is the /api/snapshot support bundle: everything needed to look at
+// a problem offline, in one file that can be attached to an issue.
+type Snapshot struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Current     Metrics      `json:"current"`
+	Alerts      []Alert      `json:"alerts"`
+	History     []Metrics    `json:"history"`
+	Diag        []DiagResult `json:"diag"`
+	Errors      []ErrorEvent `json:"errors"`
+}
+
+func buildSnapshot() Snapshot {
+	mtx.RLock()
+	cur := current
+	mtx.RUnlock()
+	errMtx.Lock()
+	errs := append([]ErrorEvent{}, recentErrors...)
+	errMtx.Unlock()
+	alerts := cur.Alerts
+	if alerts == nil {
+		alerts = []Alert{}
+	}
+	return Snapshot{
+		GeneratedAt: time.Now().In(tz),
+		Current:     cur,
+		Alerts:      alerts,
+		History:     snapshotHistory(),
+		Diag:        runDiag(),
+		Errors:      errs,
+	}
+}
+
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	s := buildSnapshot()
+	b, _ := marshalJSON(s)
+	name := "sysdash-" + s.GeneratedAt.Format("20060102-150405") + ".json"
+	if h := s.Current.Hostname; h != "" {
+		name = "sysdash-" + h + "-" + s.GeneratedAt.Format("20060102-150405") + ".json"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(name, `"`, "")+`"`)
+	w.Write(b)
+}
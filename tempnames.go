@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// tempNames maps a lowercased sensor type to the name shown on the dashboard.
+// SYSDASH_TEMP_NAMES ("acpitz=Case,x86_pkg_temp=Processor") adds to or
+// overrides it.
+var tempNames = map[string]string{
+	"x86_pkg_temp": "CPU Package",
+	"coretemp":     "CPU",
+	"k10temp":      "CPU",
+	"zenpower":     "CPU",
+	"cpu-thermal":  "CPU",
+	"cpu_thermal":  "CPU",
+	"tcpu":         "CPU",
+	"b0d4":         "CPU",
+	"soc_thermal":  "SoC",
+	"soc-thermal":  "SoC",
+	"gpu-thermal":  "GPU",
+	"gpu_thermal":  "GPU",
+	"acpitz":       "Motherboard",
+	"iwlwifi":      "Wi-Fi",
+	"iwlwifi_1":    "Wi-Fi",
+	"nvme":         "NVMe SSD",
+	"amdgpu":       "GPU",
+}
+
+// tempNamePrefixes catches families with per-model suffixes (pch_cannonlake,
+// pch_skylake, ...) after an exact lookup fails.
+var tempNamePrefixes = []struct{ prefix, name string }{
+	{"pch_", "Chipset"},
+	{"iwlwifi", "Wi-Fi"},
+}
+
+func loadTempNames() {
+	for _, p := range strings.Split(os.Getenv("SYSDASH_TEMP_NAMES"), ",") {
+		sensor, name, ok := strings.Cut(p, "=")
+		sensor, name = strings.ToLower(strings.TrimSpace(sensor)), strings.TrimSpace(name)
+		if !ok || sensor == "" || name == "" {
+			if strings.TrimSpace(p) != "" {
+				log.Printf("ignoring malformed SYSDASH_TEMP_NAMES entry %q, want sensor=Name", p)
+			}
+			continue
+		}
+		tempNames[sensor] = name
+	}
+}
+
+// tempFriendlyName names t for people: "CPU Package", "Motherboard", and for
+// hwmon chips with per-input labels "CPU Core 0". Unknown sensors keep their
+// label.
+func tempFriendlyName(t Temp) string {
+	key := strings.ToLower(t.Sensor)
+	name, ok := tempNames[key]
+	for _, p := range tempNamePrefixes {
+		if !ok && strings.HasPrefix(key, p.prefix) {
+			name, ok = p.name, true
+		}
+	}
+	switch {
+	case !ok && t.Label != "":
+		return t.Label
+	case !ok:
+		return t.Sensor
+	case t.Label == "" || t.Label == t.Sensor:
+		return name
+	case strings.HasPrefix(t.Label, "Package"):
+		// coretemp's "Package id 0"
+		return name + " Package"
+	}
+	return name + " " + t.Label
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Most settings fall back to their default when the env value doesn't parse,
+// which keeps a typo from taking the dashboard down but also hides it. -validate
+// re-checks every setting strictly, prints what would be used and exits
+// non-zero listing the problems, so CI can catch them before a deploy.
+
+var (
+	durationEnvs = []string{
+		"SYSDASH_INTERVAL", "SYSDASH_HISTORY_DURATION", "SYSDASH_PUSH_TIMEOUT",
+		"SYSDASH_COLLECT_TIMEOUT", "SYSDASH_AGGREGATE_STALE", "SYSDASH_INGEST_TIMEOUT",
+		"SYSDASH_READ_HEADER_TIMEOUT", "SYSDASH_READ_TIMEOUT", "SYSDASH_WRITE_TIMEOUT",
+		"SYSDASH_IDLE_TIMEOUT", "SYSDASH_FLAP_WINDOW", "SYSDASH_PERSIST_RAW",
+		"SYSDASH_PERSIST_KEEP", "SYSDASH_FORECAST_MIN_WINDOW",
+		"SYSDASH_WARMUP", "SYSDASH_JITTER",
+	}
+	// where 0 means "no timeout" (the server settings) or "off"
+	zeroDurationEnvs = []string{
+		"SYSDASH_READ_HEADER_TIMEOUT", "SYSDASH_READ_TIMEOUT", "SYSDASH_WRITE_TIMEOUT",
+		"SYSDASH_IDLE_TIMEOUT", "SYSDASH_WARMUP", "SYSDASH_JITTER",
+	}
+	intEnvs = []struct {
+		key string
+		min int64
+	}{
+		{"SYSDASH_HISTORY_LEN", 1},
+		{"SYSDASH_HISTORY_MAX_RESPONSE", 0},
+		{"SYSDASH_TOP_N", 0},
+		{"SYSDASH_MAX_CONNS", 0},
+		{"SYSDASH_INGEST_MAX_BYTES", 1},
+	}
+	portEnvs = []string{"SYSDASH_PORT", "SYSDASH_PPROF_PORT"}
+)
+
+// validateConfig returns one message per problem found in the environment,
+// the output location and the listen address.
+func validateConfig(addr string) []string {
+	var problems []string
+	bad := func(format string, args ...any) { problems = append(problems, fmt.Sprintf(format, args...)) }
+
+	for _, k := range durationEnvs {
+		if v := os.Getenv(k); v != "" {
+			d, err := time.ParseDuration(v)
+			switch {
+			case slices.Contains(zeroDurationEnvs, k) && (err != nil || d < 0):
+				bad("%s=%q: want a duration such as 2s, or 0 to disable", k, v)
+			case !slices.Contains(zeroDurationEnvs, k) && (err != nil || d <= 0):
+				bad("%s=%q: want a positive duration such as 2s", k, v)
+			}
+		}
+	}
+	for _, e := range intEnvs {
+		if v := os.Getenv(e.key); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err != nil || n < e.min {
+				bad("%s=%q: want an integer >= %d", e.key, v, e.min)
+			}
+		}
+	}
+	for _, k := range portEnvs {
+		if v := os.Getenv(k); v != "" {
+			if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+				bad("%s=%q: not a port number", k, v)
+			}
+		}
+	}
+	if v := os.Getenv("SYSDASH_RATE_LIMIT"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err != nil || f < 0 {
+			bad("SYSDASH_RATE_LIMIT=%q: want a number >= 0", v)
+		}
+	}
+	if v := strings.ToLower(os.Getenv("SYSDASH_LOAD_SOURCE")); v != "" && v != "loadavg" && v != "psi" {
+		bad("SYSDASH_LOAD_SOURCE=%q: want loadavg or psi", v)
+	}
+	if v := strings.ToLower(os.Getenv("SYSDASH_LOG_FORMAT")); v != "" && v != "text" && v != "json" {
+		bad("SYSDASH_LOG_FORMAT=%q: want text or json", v)
+	}
+	for _, n := range envList("SYSDASH_COLLECTORS") {
+		if !slices.Contains(collectorNames, n) {
+			bad("SYSDASH_COLLECTORS: unknown collector %q (want %s)", n, strings.Join(collectorNames, ", "))
+		}
+	}
+	if v := os.Getenv("SYSDASH_BIND_DEVICE"); v != "" {
+		if _, err := net.InterfaceByName(v); err != nil {
+			bad("SYSDASH_BIND_DEVICE=%q: %v", v, err)
+		}
+	}
+	if v := os.Getenv("SYSDASH_NET_FILTER"); v != "" {
+		if _, err := regexp.Compile(v); err != nil {
+			bad("SYSDASH_NET_FILTER=%q: %v", v, err)
+		}
+	}
+	if v := os.Getenv("SYSDASH_FLOAT_DECIMALS"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < -1 || n > 15 {
+			bad("SYSDASH_FLOAT_DECIMALS=%q: want -1 (full precision) to 15", v)
+		}
+	}
+	if _, err := responseHeaders(); err != nil {
+		bad("%v", err)
+	}
+	if v := os.Getenv("SYSDASH_TZ"); v != "" {
+		if _, err := time.LoadLocation(v); err != nil {
+			bad("SYSDASH_TZ=%q: %v", v, err)
+		}
+	}
+	if v := os.Getenv("SYSDASH_CPU_EMA"); v != "" {
+		if a, err := strconv.ParseFloat(v, 64); err != nil || a <= 0 || a > 1 {
+			bad("SYSDASH_CPU_EMA=%q: want a smoothing factor 0 < alpha <= 1", v)
+		}
+	}
+	if err := loadHealthConfig(); err != nil {
+		bad("%v", err)
+	}
+	if err := loadAlertConfig(); err != nil {
+		bad("%v", err)
+	}
+	if v := os.Getenv("SYSDASH_OUTDIR"); v != "" {
+		if _, err := expandPath(v); err != nil {
+			bad("SYSDASH_OUTDIR=%q: %v", v, err)
+		}
+	}
+	if v := strings.TrimRight(os.Getenv("SYSDASH_BASE_PATH"), "/"); v != "" && !strings.HasPrefix(v, "/") {
+		bad("SYSDASH_BASE_PATH=%q: must start with '/'", v)
+	}
+	if os.Getenv("SYSDASH_HISTORY_LEN") != "" && os.Getenv("SYSDASH_HISTORY_DURATION") != "" {
+		bad("SYSDASH_HISTORY_LEN and SYSDASH_HISTORY_DURATION are mutually exclusive")
+	}
+	if pushURL != "" {
+		if u, err := url.Parse(pushURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("SYSDASH_PUSH_URL=%q: want an http(s) URL", pushURL)
+		}
+	}
+
+	if sock, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if err := probeWritable(filepath.Dir(sock)); err != nil {
+			bad("unix socket directory %s: %v", filepath.Dir(sock), err)
+		}
+		if v := os.Getenv("SYSDASH_UNIX_SOCKET_MODE"); v != "" {
+			if m, err := strconv.ParseUint(v, 8, 32); err != nil || m > 0o777 {
+				bad("SYSDASH_UNIX_SOCKET_MODE=%q: want an octal mode such as 0660", v)
+			}
+		}
+	} else if host, port, err := net.SplitHostPort(addr); err != nil {
+		bad("listen address %q: %v", addr, err)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		bad("listen address %q: bad port", addr)
+	} else if host != "" && net.ParseIP(host) == nil {
+		if _, err := net.LookupHost(host); err != nil {
+			bad("listen address %q: %v", addr, err)
+		}
+	}
+
+	// with -no-file nothing is written, so the output location doesn't matter
+	if !noFile {
+		if err := validateOutput(outDir, outFile); err != nil {
+			bad("output: %v", err)
+		} else if err := probeWritable(outDir); err != nil {
+			bad("output directory %s is not writable: %v", outDir, err)
+		}
+	}
+	if promTextfile != "" {
+		if err := probeWritable(filepath.Dir(promTextfile)); err != nil {
+			bad("SYSDASH_PROM_TEXTFILE directory %s is not writable: %v", filepath.Dir(promTextfile), err)
+		}
+	}
+	for _, root := range []string{procRoot, sysRoot} {
+		if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+			bad("%s is not a readable directory", root)
+		}
+	}
+	return problems
+}
+
+// probeWritable checks that dir (or, if it doesn't exist yet, the closest
+// existing parent that ensureDir would create it under) accepts a new file.
+func probeWritable(dir string) error {
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".sysdash-validate-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// runValidate prints the resolved configuration and any problems, returning
+// the process exit code.
+func runValidate(addr string) int {
+	fmt.Printf("listen:           %s\n", addr)
+	fmt.Printf("interval:         %s\n", sampleEvery)
+	if noFile {
+		fmt.Printf("output:           (none, -no-file)\n")
+	} else {
+		fmt.Printf("output:           %s\n", filepath.Join(outDir, outFile))
+	}
+	if historyDuration > 0 {
+		fmt.Printf("history:          %s\n", historyDuration)
+	} else {
+		fmt.Printf("history:          %d samples\n", historyLen)
+	}
+	fmt.Printf("collect timeout:  %s\n", collectTimeout)
+	fmt.Printf("time zone:        %s\n", tz)
+	fmt.Printf("proc/sys roots:   %s %s\n", procRoot, sysRoot)
+	if pushURL != "" {
+		fmt.Printf("push:             %s (timeout %s)\n", pushURL, pushTimeout)
+	}
+
+	problems := validateConfig(addr)
+	if len(problems) == 0 {
+		fmt.Println("config OK")
+		return 0
+	}
+	fmt.Printf("%d problem(s):\n", len(problems))
+	for _, p := range problems {
+		fmt.Println("  - " + p)
+	}
+	return 1
+}
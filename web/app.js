@@ -1,9 +1,11 @@
+const BASE = window.SYSDASH_BASE || ''; // set by the server when running under a subpath
 const MAX_POINTS = 120; // keep ~3 minutes at 1.5s refresh
 const state = {
   labels: [],
   cpu: [],
   memUsed: [],
   memTotal: 0,
+  memCgroup: false,
   load1: [], load5: [], load15: [],
   netRx: [], netTx: [], // total bytes across up interfaces
 };
@@ -16,13 +18,13 @@ function fmtBytes(n) {
 function el(id){ return document.getElementById(id); }
 
 async function fetchMetrics() {
-  const res = await fetch('/api/metrics', { cache: 'no-store' });
+  const res = await fetch(`${BASE}/api/metrics`, { cache: 'no-store' });
   if (!res.ok) throw new Error('metrics fetch failed');
   return await res.json();
 }
 
 async function fetchHistory() {
-  const res = await fetch('/api/history', { cache: 'no-store' });
+  const res = await fetch(`${BASE}/api/history`, { cache: 'no-store' });
   if (!res.ok) return [];
   return await res.json();
 }
@@ -118,15 +120,18 @@ function updateState(m) {
   // labels
   pushAndTrim(state.labels, label);
 
-  // cpu
-  pushAndTrim(state.cpu, Number(m.cpu_percent?.toFixed(1) || 0));
+  // cpu (the smoothed series when the server has SYSDASH_CPU_EMA set)
+  const cpu = m.cpu_percent_smoothed ?? m.cpu_percent;
+  pushAndTrim(state.cpu, Number(cpu?.toFixed(1) || 0));
 
   // mem
   state.memTotal = (m.mem_total_bytes || 0) / (1024*1024);
+  state.memCgroup = !!m.mem_cgroup;
   const usedMB = ((m.mem_total_bytes||0) - (m.mem_available_bytes||0)) / (1024*1024);
   pushAndTrim(state.memUsed, usedMB);
 
   // load
+  state.loadSource = m.load_source || 'loadavg';
   pushAndTrim(state.load1, Number(m.load1||0));
   pushAndTrim(state.load5, Number(m.load5||0));
   pushAndTrim(state.load15, Number(m.load15||0));
@@ -145,32 +150,80 @@ function updateState(m) {
   el('meta').textContent =
     `${m.hostname} • ${m.os} • ${m.kernel} • ${new Date(m.timestamp).toLocaleString()}`;
 
+  // health tile: green/amber/red from the server-side score
+  if (m.health_status) {
+    const h = el('health');
+    h.textContent = `● ${Math.round(m.health_score)}`;
+    h.className = 'mono ' + ({ green: 'ok', amber: 'warn', red: 'bad' }[m.health_status] || '');
+    h.title = [`health: ${m.health_status}`, ...(m.alerts||[]).map(a => `${a.severity}: ${a.msg}`)].join('\n');
+  }
+
   if (typeof m.uptime_sec === "number") setUptimeFromMetrics(m)
 
   // net table
   el('netTbl').innerHTML =
-    `<table><tr><th>IF</th><th>Status</th><th>IPv4</th><th>RX</th><th>TX</th></tr>` +
+    `<table><tr><th>IF</th><th>Status</th><th>Speed</th><th>IPv4</th><th>IPv6</th><th>RX</th><th>TX</th></tr>` +
     (m.net||[]).map(n =>
       `<tr>
-        <td class="mono">${n.name}</td>
+        <td class="mono" title="${n.name} ${n.mac||''} mtu ${n.mtu||''}">${n.display_name||n.name}</td>
         <td class="${n.oper_up?'ok':'bad'}">${n.oper_up?'up':'down'}</td>
+        <td>${n.speed_mbps ? (n.speed_mbps >= 1000 ? `${n.speed_mbps/1000}G` : `${n.speed_mbps}M`) : ''}</td>
         <td class="mono">${n.addr_ipv4||''}</td>
+        <td class="mono">${(n.addrs_ipv6||[]).join('<br/>')}</td>
         <td>${fmtBytes(n.rx_bytes||0)}</td>
         <td>${fmtBytes(n.tx_bytes||0)}</td>
       </tr>`
     ).join('') + `</table>`;
 
+  // disks
+  el('disks').innerHTML =
+    (m.disks&&m.disks.length ?
+      `<table><tr><th>Mount</th><th>Used</th><th>Size</th><th>Inodes</th></tr>` +
+      m.disks.map(d =>
+        `<tr>
+          <td class="mono">${d.mount}${d.read_only ? ' <span class="warn">ro</span>' : ''}</td>
+          <td class="${d.used_pct>90?'bad':d.used_pct>80?'warn':''}">${(d.used_pct||0).toFixed(1)}%</td>
+          <td>${fmtBytes(d.total_bytes||0)}</td>
+          <td class="${d.inodes_used_pct>90?'bad':d.inodes_used_pct>80?'warn':''}">${(d.inodes_used_pct||0).toFixed(1)}%</td>
+        </tr>`
+      ).join('') + `</table>` : '—') +
+    (m.disk_io&&m.disk_io.length ?
+      `<table><tr><th>Device</th><th>Read/s</th><th>Write/s</th><th>Util</th></tr>` +
+      m.disk_io.map(d =>
+        `<tr>
+          <td class="mono">${d.device}</td>
+          <td>${fmtBytes(d.read_bps||0)}</td>
+          <td>${fmtBytes(d.write_bps||0)}</td>
+          <td class="${d.util_percent>90?'bad':d.util_percent>60?'warn':''}">${(d.util_percent||0).toFixed(0)}%</td>
+        </tr>`
+      ).join('') + `</table>` : '') +
+    (m.raid_arrays&&m.raid_arrays.length ?
+      `<table><tr><th>Array</th><th>Level</th><th>State</th><th>Devices</th></tr>` +
+      m.raid_arrays.map(a =>
+        `<tr>
+          <td class="mono">${a.name}</td>
+          <td>${a.level||'—'}</td>
+          <td class="${a.degraded?'bad':a.sync_action?'warn':''}">${a.degraded?'degraded':a.state}${a.sync_action ? ` (${a.sync_action} ${(a.sync_percent||0).toFixed(1)}%)` : ''}</td>
+          <td>${a.active}/${a.devices}${a.failed ? ` <span class="bad">${a.failed} failed</span>` : ''}</td>
+        </tr>`
+      ).join('') + `</table>` : '');
+
   // temps
   el('temps').innerHTML =
-    (m.temps&&m.temps.length ? m.temps.map(t => `${t.sensor}: <b>${(t.c||0).toFixed(1)}°C</b>`).join('<br/>') : '—');
+    (m.temps&&m.temps.length ? m.temps.map(t => `<span title="${t.sensor}${t.label&&t.label!==t.sensor?' / '+t.label:''}">${t.friendly_name||t.label||t.sensor}</span>: <b>${(t.celsius||0).toFixed(1)}°C</b>`).join('<br/>') : '—');
 }
 
 function refreshCharts() {
   cpuChart.update();
   if (state.memTotal) {
-    memChart.options.scales.y.title.text = `MB (Total: ${state.memTotal.toFixed(0)})`;
+    memChart.options.scales.y.title.text = `MB (${state.memCgroup ? 'Container limit' : 'Total'}: ${state.memTotal.toFixed(0)})`;
   }
   memChart.update();
+  // with SYSDASH_LOAD_SOURCE=psi the three series are CPU pressure averages
+  const psi = state.loadSource === 'psi';
+  el('loadTitle').textContent = psi ? 'CPU Pressure' : 'Load Avg';
+  loadChart.data.datasets.forEach((d, i) => { d.label = (psi ? ['10s', '60s', '300s'] : ['1m', '5m', '15m'])[i]; });
+  loadChart.options.scales.y.title.text = psi ? '% stalled' : 'load';
   loadChart.update();
   netChart.update();
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 6455 server: enough to push text frames and answer
+// ping/close, without pulling in a dependency. Client messages other than
+// control frames are read and discarded.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	wsPingEvery = 30 * time.Second
+	// client frames are tiny (pong/close); anything bigger is not ours
+	wsMaxFrame = int64(64 << 10)
+	// SYSDASH_WS_ORIGINS: extra origins (https://portal.lan) or hosts
+	// (portal.lan:8443) allowed to open /api/ws from another site; "*" allows any
+	wsOrigins []string
+)
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+type wsConn struct {
+	c  net.Conn
+	br *bufio.Reader
+	mu sync.Mutex // serialises writes from the push loop and the reader
+}
+
+func (ws *wsConn) writeFrame(op byte, p []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	hdr := []byte{0x80 | op}
+	switch n := len(p); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 126, byte(n>>8), byte(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	ws.c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := ws.c.Write(hdr); err != nil {
+		return err
+	}
+	_, err := ws.c.Write(p)
+	return err
+}
+
+// readFrame returns the next client frame. Clients must mask; fragmented
+// messages are accepted but only control frames matter to us.
+func (ws *wsConn) readFrame() (op byte, p []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(ws.br, h[:]); err != nil {
+		return
+	}
+	op = h[0] & 0x0F
+	if h[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	n := int64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(ws.br, b[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(ws.br, b[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint64(b[:]))
+	}
+	if n < 0 || n > wsMaxFrame {
+		return 0, nil, errors.New("frame too large")
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+		return
+	}
+	p = make([]byte, n)
+	if _, err = io.ReadFull(ws.br, p); err != nil {
+		return
+	}
+	for i := range p {
+		p[i] ^= mask[i%4]
+	}
+	return op, p, nil
+}
+
+func headerHas(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsOriginAllowed guards against cross-site WebSocket hijacking: browsers
+// don't apply the same-origin policy to WebSockets, so without this any page
+// a LAN user visits could stream the samples. Non-browser clients send no
+// Origin and are let through.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range wsOrigins {
+		if o == "*" || strings.EqualFold(o, origin) || strings.EqualFold(o, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWS upgrades to a WebSocket and sends every new sample as a JSON text
+// frame, starting with the current one.
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	if !wsOriginAllowed(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	c, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	defer c.Close()
+	// drop the server's read/write timeouts; keepalive is handled with pings
+	c.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	ws := &wsConn{c: c, br: rw.Reader}
+
+	ch := subscribe()
+	defer unsubscribe(ch)
+
+	// reader: answers pings, notices close/dead peers. Any frame (usually our
+	// pong) pushes the read deadline out; two missed pings end the connection.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			c.SetReadDeadline(time.Now().Add(2*wsPingEvery + 5*time.Second))
+			op, p, err := ws.readFrame()
+			if err != nil {
+				return
+			}
+			switch op {
+			case wsPing:
+				ws.writeFrame(wsPong, p)
+			case wsClose:
+				ws.writeFrame(wsClose, nil)
+				return
+			}
+		}
+	}()
+
+	mtx.RLock()
+	b := currentJSON
+	mtx.RUnlock()
+	if b != nil {
+		if err := ws.writeFrame(wsText, b); err != nil {
+			return
+		}
+	}
+	ping := time.NewTicker(wsPingEvery)
+	defer ping.Stop()
+	for {
+		select {
+		case b := <-ch:
+			err = ws.writeFrame(wsText, b)
+		case <-ping.C:
+			err = ws.writeFrame(wsPing, nil)
+		case <-done:
+			return
+		}
+		if err != nil {
+			logErr(err, "[ws] %s", r.RemoteAddr)
+			return
+		}
+	}
+}